@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	csh_auth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/csrf"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// adminGroups are the OIDC groups whose members may override any poll
+var adminGroups = []string{"eboard", "rtp"}
+
+// adminOverride is a single entry from a poll's history made by an administrator
+type adminOverride struct {
+	Poll  *database.Poll
+	Entry database.HistoryEntry
+}
+
+func isAdmin(groups []string) bool {
+	for _, group := range groups {
+		if containsString(adminGroups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func adminDashboard(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+	if !isAdmin(claims.UserInfo.Groups) {
+		c.JSON(403, gin.H{"error": "Only administrators can view the admin dashboard"})
+		return
+	}
+
+	polls, err := database.GetAllPolls(c)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	overrides := []adminOverride{}
+	for _, poll := range polls {
+		for _, entry := range poll.History {
+			if strings.HasPrefix(entry.Action, "admin-") {
+				overrides = append(overrides, adminOverride{Poll: poll, Entry: entry})
+			}
+		}
+	}
+	sort.Slice(overrides, func(i, j int) bool {
+		return overrides[i].Entry.Time.After(overrides[j].Entry.Time)
+	})
+
+	c.HTML(200, "admin.tmpl", gin.H{
+		"Polls":     polls,
+		"Overrides": overrides,
+		"Username":  claims.UserInfo.Username,
+		"FullName":  claims.UserInfo.FullName,
+		"CSRFToken": csrf.Token(c),
+	})
+}
+
+// adminOverridePoll lets an administrator change any poll, recording why in its history
+func adminOverridePoll(broker *sse.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cl, _ := c.Get("cshauth")
+		claims := cl.(csh_auth.CSHClaims)
+		if !isAdmin(claims.UserInfo.Groups) {
+			c.JSON(403, gin.H{"error": "Only administrators can override a poll"})
+			return
+		}
+
+		reason := strings.TrimSpace(c.PostForm("reason"))
+		if reason == "" {
+			c.JSON(400, gin.H{"error": "A reason is required to override a poll"})
+			return
+		}
+
+		poll, err := database.GetPoll(c, c.Param("id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		action := c.PostForm("action")
+		details, newOwner := "", ""
+		wasOpen := poll.Open
+		switch action {
+		case "close", "reopen", "hide", "reveal", "archive", "unarchive":
+		case "transfer":
+			newOwner = strings.TrimSpace(c.PostForm("newOwner"))
+			if newOwner == "" {
+				c.JSON(400, gin.H{"error": "A new owner is required to transfer a poll"})
+				return
+			}
+			details = fmt.Sprintf("owner %s -> %s", poll.CreatedBy, newOwner)
+		default:
+			c.JSON(400, gin.H{"error": "Unknown action"})
+			return
+		}
+
+		err = poll.Override(c, action, newOwner, database.HistoryEntry{
+			Time:    time.Now(),
+			User:    claims.UserInfo.Username,
+			Action:  "admin-" + action,
+			Details: details,
+			Reason:  reason,
+		})
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		logAction(c, "admin."+action, logrus.Fields{"poll": poll.Id, "reason": reason, "details": details})
+
+		switch {
+		case action == "reopen":
+			notifyOpened(c, broker, poll)
+		case action == "hide" || action == "reveal":
+			notifyVisibility(c, broker, poll)
+		case wasOpen && !poll.Open:
+			notifyClosed(c, broker, poll)
+		}
+
+		c.Redirect(302, "/admin")
+	}
+}
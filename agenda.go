@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	csh_auth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/csrf"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	maxAgendaSize  = 1 << 20
+	maxAgendaPolls = 50
+)
+
+// agenda describes several polls to create at once, written in YAML or JSON:
+//
+//	polls:
+//	  - shortDescription: Approve the budget
+//	    options: pass-fail
+//	  - shortDescription: Chair
+//	    voteType: ranked
+//	    options:
+//	      - Alice
+//	      - name: Bob
+//	        description: Incumbent
+//	    opensAt: 2022-09-01T19:00:00-04:00
+//	    closesAt: 2022-09-01T21:00:00-04:00
+type agenda struct {
+	Polls []agendaPoll `yaml:"polls" json:"polls"`
+}
+
+type agendaPoll struct {
+	ShortDescription string        `yaml:"shortDescription" json:"shortDescription"`
+	LongDescription  string        `yaml:"longDescription" json:"longDescription"`
+	VoteType         string        `yaml:"voteType" json:"voteType"`
+	Options          agendaOptions `yaml:"options" json:"options"`
+	AllowWriteIns    bool          `yaml:"allowWriteIns" json:"allowWriteIns"`
+	OpenBallots      bool          `yaml:"openBallots" json:"openBallots"`
+	Official         bool          `yaml:"official" json:"official"`
+	OpensAt          *time.Time    `yaml:"opensAt" json:"opensAt"`
+	ClosesAt         *time.Time    `yaml:"closesAt" json:"closesAt"`
+}
+
+// agendaOptions is either the name of one of the option presets, or a list of options
+type agendaOptions struct {
+	Preset string
+	List   []agendaOption
+}
+
+// agendaOption is either just the option's name, or its name and description
+type agendaOption struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description" json:"description"`
+}
+
+func (o *agendaOptions) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&o.Preset); err == nil {
+		return nil
+	}
+	return unmarshal(&o.List)
+}
+
+func (o *agendaOptions) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &o.Preset); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &o.List)
+}
+
+func (o *agendaOption) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&o.Name); err == nil {
+		return nil
+	}
+	type plain agendaOption
+	return unmarshal((*plain)(o))
+}
+
+func (o *agendaOption) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &o.Name); err == nil {
+		return nil
+	}
+	type plain agendaOption
+	return json.Unmarshal(data, (*plain)(o))
+}
+
+// parseAgenda decodes an agenda, treating it as JSON if it looks like JSON and YAML otherwise
+func parseAgenda(data []byte) (*agenda, error) {
+	var a agenda
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&a); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+	} else if err := yaml.UnmarshalStrict(data, &a); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %v", err)
+	}
+
+	if len(a.Polls) == 0 {
+		return nil, errors.New("the agenda doesn't contain any polls")
+	}
+	if len(a.Polls) > maxAgendaPolls {
+		return nil, fmt.Errorf("an agenda can contain at most %d polls", maxAgendaPolls)
+	}
+	return &a, nil
+}
+
+// agendaToPolls validates every poll in an agenda, returning the polls ready to
+// be created, or every problem found if there are any
+func agendaToPolls(a *agenda, claims csh_auth.CSHClaims, now time.Time) ([]*database.Poll, []string) {
+	var problems []string
+	polls := make([]*database.Poll, 0, len(a.Polls))
+
+	for i, item := range a.Polls {
+		poll := &database.Poll{
+			CreatedBy:        claims.UserInfo.Username,
+			ShortDescription: strings.TrimSpace(item.ShortDescription),
+			LongDescription:  strings.TrimSpace(item.LongDescription),
+			VoteType:         item.VoteType,
+			Open:             true,
+			AllowWriteIns:    item.AllowWriteIns,
+			OpenBallots:      item.OpenBallots,
+			Official:         item.Official,
+			OpensAt:          item.OpensAt,
+			ClosesAt:         item.ClosesAt,
+		}
+		if poll.VoteType == "" {
+			poll.VoteType = database.POLL_TYPE_SIMPLE
+		}
+
+		errs := make(map[string]string)
+		validateDescription(poll.ShortDescription, errs)
+		if poll.VoteType != database.POLL_TYPE_SIMPLE && poll.VoteType != database.POLL_TYPE_RANKED {
+			errs["voteType"] = fmt.Sprintf("Unknown vote type %q", poll.VoteType)
+		}
+		if poll.Official && !canMarkOfficial(claims.UserInfo.Groups) {
+			errs["official"] = "You aren't allowed to mark polls as official"
+		}
+		if poll.OpensAt != nil && poll.OpensAt.After(now) {
+			poll.Open = false
+			poll.Pending = true
+		}
+		if poll.ClosesAt != nil {
+			if !poll.ClosesAt.After(now) {
+				errs["closesAt"] = "The closing time has already passed"
+			} else if poll.OpensAt != nil && !poll.ClosesAt.After(*poll.OpensAt) {
+				errs["closesAt"] = "The closing time must be after the opening time"
+			}
+		}
+
+		if len(item.Options.List) > 0 {
+			inputs := make([]OptionInput, 0, len(item.Options.List))
+			for _, opt := range item.Options.List {
+				inputs = append(inputs, OptionInput{Name: strings.TrimSpace(opt.Name), Description: strings.TrimSpace(opt.Description)})
+			}
+			if validateOptions(inputs, errs) {
+				applyOptions(poll, inputs)
+			} else {
+				for _, input := range inputs {
+					if input.Error != "" {
+						errs["options"] += fmt.Sprintf("; %q: %s", input.Name, input.Error)
+					}
+				}
+			}
+		} else if item.Options.Preset == "" {
+			poll.Options = append([]string{}, optionPresets["pass-fail"]...)
+		} else if preset, ok := optionPresets[item.Options.Preset]; ok {
+			poll.Options = append([]string{}, preset...)
+		} else {
+			errs["options"] = fmt.Sprintf("Unknown options preset %q", item.Options.Preset)
+		}
+
+		for _, field := range []string{"shortDescription", "voteType", "official", "closesAt", "options"} {
+			if message, ok := errs[field]; ok {
+				problems = append(problems, fmt.Sprintf("Poll %d (%s): %s", i+1, field, message))
+			}
+		}
+		polls = append(polls, poll)
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return polls, nil
+}
+
+func agendaPage(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+	if !canVote(claims.UserInfo.Groups) {
+		c.HTML(403, "unauthorized.tmpl", gin.H{
+			"Username": claims.UserInfo.Username,
+			"FullName": claims.UserInfo.FullName,
+		})
+		return
+	}
+
+	c.HTML(200, "agenda.tmpl", gin.H{
+		"Username":  claims.UserInfo.Username,
+		"FullName":  claims.UserInfo.FullName,
+		"CSRFToken": csrf.Token(c),
+	})
+}
+
+func createAgenda(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+	if !canVote(claims.UserInfo.Groups) {
+		c.HTML(403, "unauthorized.tmpl", gin.H{
+			"Username": claims.UserInfo.Username,
+			"FullName": claims.UserInfo.FullName,
+		})
+		return
+	}
+
+	renderProblems := func(problems []string) {
+		c.HTML(400, "agenda.tmpl", gin.H{
+			"Problems":  problems,
+			"Agenda":    c.PostForm("agenda"),
+			"Username":  claims.UserInfo.Username,
+			"FullName":  claims.UserInfo.FullName,
+			"CSRFToken": csrf.Token(c),
+		})
+	}
+
+	data := []byte(c.PostForm("agenda"))
+	if header, err := c.FormFile("file"); err == nil {
+		if header.Size > maxAgendaSize {
+			renderProblems([]string{"Agenda files can't be larger than 1 MB"})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		defer file.Close()
+		if data, err = io.ReadAll(file); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	a, err := parseAgenda(data)
+	if err != nil {
+		renderProblems([]string{err.Error()})
+		return
+	}
+	polls, problems := agendaToPolls(a, claims, time.Now())
+	if len(problems) > 0 {
+		renderProblems(problems)
+		return
+	}
+
+	ids, err := database.CreatePolls(c, polls)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	logAction(c, "poll.create.agenda", logrus.Fields{"polls": ids})
+	c.Redirect(302, "/")
+}
+
+func createAgendaAPI(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+	if !canVote(claims.UserInfo.Groups) {
+		c.JSON(403, gin.H{"error": "You're not authorized to create polls"})
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAgendaSize+1))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if len(data) > maxAgendaSize {
+		c.JSON(413, gin.H{"error": "Agendas can't be larger than 1 MB"})
+		return
+	}
+
+	a, err := parseAgenda(data)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	polls, problems := agendaToPolls(a, claims, time.Now())
+	if len(problems) > 0 {
+		c.JSON(400, gin.H{"error": "The agenda has problems", "problems": problems})
+		return
+	}
+
+	ids, err := database.CreatePolls(c, polls)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	logAction(c, "poll.create.agenda", logrus.Fields{"polls": ids})
+	c.JSON(201, gin.H{"ids": ids})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	csh_auth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+)
+
+func TestParseAgenda(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *agenda
+		wantErr string
+	}{
+		{
+			name: "YAML with a preset and a list of options",
+			input: `polls:
+  - shortDescription: Approve the budget
+    options: pass-fail
+  - shortDescription: Chair
+    voteType: ranked
+    options:
+      - Alice
+      - name: Bob
+        description: Incumbent
+`,
+			want: &agenda{Polls: []agendaPoll{
+				{ShortDescription: "Approve the budget", Options: agendaOptions{Preset: "pass-fail"}},
+				{ShortDescription: "Chair", VoteType: "ranked", Options: agendaOptions{List: []agendaOption{
+					{Name: "Alice"},
+					{Name: "Bob", Description: "Incumbent"},
+				}}},
+			}},
+		},
+		{
+			name:  "JSON",
+			input: `  {"polls": [{"shortDescription": "Chair", "options": ["Alice", {"name": "Bob", "description": "Incumbent"}]}]}`,
+			want: &agenda{Polls: []agendaPoll{
+				{ShortDescription: "Chair", Options: agendaOptions{List: []agendaOption{
+					{Name: "Alice"},
+					{Name: "Bob", Description: "Incumbent"},
+				}}},
+			}},
+		},
+		{
+			name:    "unknown YAML field",
+			input:   "polls:\n  - shortDescription: Chair\n    closes: tomorrow\n",
+			wantErr: "invalid YAML",
+		},
+		{
+			name:    "unknown JSON field",
+			input:   `{"polls": [{"shortDescription": "Chair", "closes": "tomorrow"}]}`,
+			wantErr: "invalid JSON",
+		},
+		{
+			name:    "no polls",
+			input:   "polls: []\n",
+			wantErr: "the agenda doesn't contain any polls",
+		},
+		{
+			name:    "too many polls",
+			input:   "polls:\n" + strings.Repeat("  - shortDescription: Poll\n", maxAgendaPolls+1),
+			wantErr: "an agenda can contain at most 50 polls",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseAgenda([]byte(test.input))
+			if test.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+					t.Fatalf("parseAgenda() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAgenda() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseAgenda() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAgendaToPolls(t *testing.T) {
+	now := time.Date(2022, 9, 1, 18, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+	member := csh_auth.CSHClaims{UserInfo: csh_auth.CSHUserInfo{Username: "member"}}
+	eboard := csh_auth.CSHClaims{UserInfo: csh_auth.CSHUserInfo{Username: "chair", Groups: []string{"eboard"}}}
+
+	tests := []struct {
+		name     string
+		poll     agendaPoll
+		claims   csh_auth.CSHClaims
+		want     *database.Poll
+		problems []string
+	}{
+		{
+			name:   "defaults to a pass-fail simple poll",
+			poll:   agendaPoll{ShortDescription: "  Approve the budget  "},
+			claims: member,
+			want: &database.Poll{
+				CreatedBy:        "member",
+				ShortDescription: "Approve the budget",
+				VoteType:         database.POLL_TYPE_SIMPLE,
+				Open:             true,
+				Options:          []string{"Pass", "Fail", "Abstain"},
+			},
+		},
+		{
+			name: "ranked poll opening later",
+			poll: agendaPoll{
+				ShortDescription: "Chair",
+				VoteType:         database.POLL_TYPE_RANKED,
+				Options:          agendaOptions{List: []agendaOption{{Name: "Alice"}, {Name: "Bob", Description: "Incumbent"}}},
+				Official:         true,
+				OpensAt:          &later,
+			},
+			claims: eboard,
+			want: &database.Poll{
+				CreatedBy:          "chair",
+				ShortDescription:   "Chair",
+				VoteType:           database.POLL_TYPE_RANKED,
+				Pending:            true,
+				Official:           true,
+				OpensAt:            &later,
+				Options:            []string{"Alice", "Bob"},
+				OptionDescriptions: []database.OptionDescription{{Option: "Bob", Description: "Incumbent"}},
+			},
+		},
+		{
+			name:     "unknown preset",
+			poll:     agendaPoll{ShortDescription: "Budget", Options: agendaOptions{Preset: "yes-no"}},
+			claims:   member,
+			problems: []string{`Poll 1 (options): Unknown options preset "yes-no"`},
+		},
+		{
+			name:     "invalid options",
+			poll:     agendaPoll{ShortDescription: "Chair", Options: agendaOptions{List: []agendaOption{{Name: "Alice"}, {Name: "alice"}}}},
+			claims:   member,
+			problems: []string{`Poll 1 (options): Some options need fixing; "alice": Option is a duplicate`},
+		},
+		{
+			name: "every problem is reported",
+			poll: agendaPoll{
+				VoteType: "approval",
+				Official: true,
+				ClosesAt: &earlier,
+			},
+			claims: member,
+			problems: []string{
+				"Poll 1 (shortDescription): A short description is required",
+				`Poll 1 (voteType): Unknown vote type "approval"`,
+				"Poll 1 (official): You aren't allowed to mark polls as official",
+				"Poll 1 (closesAt): The closing time has already passed",
+			},
+		},
+		{
+			name:     "closes before it opens",
+			poll:     agendaPoll{ShortDescription: "Budget", OpensAt: &later, ClosesAt: &later},
+			claims:   member,
+			problems: []string{"Poll 1 (closesAt): The closing time must be after the opening time"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			polls, problems := agendaToPolls(&agenda{Polls: []agendaPoll{test.poll}}, test.claims, now)
+			if !reflect.DeepEqual(problems, test.problems) {
+				t.Fatalf("problems = %q, want %q", problems, test.problems)
+			}
+			if test.want == nil {
+				if polls != nil {
+					t.Errorf("polls = %+v, want none", polls)
+				}
+				return
+			}
+			if len(polls) != 1 || !reflect.DeepEqual(polls[0], test.want) {
+				t.Errorf("polls = %+v, want [%+v]", polls, test.want)
+			}
+		})
+	}
+}
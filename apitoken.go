@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	csh_auth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/csrf"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// apiTokenLifetime is how long an API token works before it has to be replaced.
+// A token keeps the groups its owner had when it was created, so it's kept well
+// short of a semester, after which those groups may no longer be right
+const apiTokenLifetime = 7 * 24 * time.Hour
+
+// hashAPIToken is how a token is stored and looked up
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// errInvalidAPIToken is returned for tokens that don't exist, were revoked, or have expired
+var errInvalidAPIToken = errors.New("invalid or expired api token")
+
+// apiTokenClaims finds who an API token belongs to, as if they had logged in
+func apiTokenClaims(c *gin.Context, token string) (csh_auth.CSHClaims, error) {
+	apiToken, err := database.GetAPITokenByHash(c, hashAPIToken(token))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return csh_auth.CSHClaims{}, errInvalidAPIToken
+	} else if err != nil {
+		return csh_auth.CSHClaims{}, err
+	}
+
+	return csh_auth.CSHClaims{
+		UserInfo: csh_auth.CSHUserInfo{
+			Username: apiToken.Username,
+			FullName: apiToken.FullName,
+			Groups:   apiToken.Groups,
+		},
+	}, nil
+}
+
+// apiTokensPage lists the user's API tokens. newToken is only shown right after it's created
+func apiTokensPage(c *gin.Context, code int, newToken string) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+
+	tokens, err := database.GetAPITokens(c, claims.UserInfo.Username)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.HTML(code, "tokens.tmpl", gin.H{
+		"Tokens":    tokens,
+		"NewToken":  newToken,
+		"Username":  claims.UserInfo.Username,
+		"FullName":  claims.UserInfo.FullName,
+		"CSRFToken": csrf.Token(c),
+	})
+}
+
+func listAPITokens(c *gin.Context) {
+	apiTokensPage(c, 200, "")
+}
+
+func createAPIToken(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		c.JSON(400, gin.H{"error": "A token needs a name, so you can tell it apart later"})
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	token := hex.EncodeToString(secret)
+
+	now := time.Now()
+	tokenId, err := database.CreateAPIToken(c, &database.APIToken{
+		Hash:      hashAPIToken(token),
+		Name:      name,
+		Username:  claims.UserInfo.Username,
+		FullName:  claims.UserInfo.FullName,
+		Groups:    claims.UserInfo.Groups,
+		CreatedAt: now,
+		ExpiresAt: now.Add(apiTokenLifetime),
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	logAction(c, "token.create", logrus.Fields{"token": tokenId})
+	apiTokensPage(c, 200, token)
+}
+
+func deleteAPIToken(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+
+	if err := database.DeleteAPIToken(c, c.Param("id"), claims.UserInfo.Username); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	logAction(c, "token.delete", logrus.Fields{"token": c.Param("id")})
+	c.Redirect(302, "/tokens")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	csh_auth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultArchivePageSize = 20
+	maxArchivePageSize     = 100
+)
+
+// archivePoll is the API representation of a poll in the archive
+type archivePoll struct {
+	Id               string    `json:"id"`
+	ShortDescription string    `json:"shortDescription"`
+	LongDescription  string    `json:"longDescription"`
+	CreatedBy        string    `json:"createdBy"`
+	CreatedAt        time.Time `json:"createdAt"`
+	VoteType         string    `json:"voteType"`
+	Outcome          string    `json:"outcome"`
+	Official         bool      `json:"official"`
+}
+
+// parseArchiveQuery builds an archive search for the current user from the request's query string
+func parseArchiveQuery(c *gin.Context, claims csh_auth.CSHClaims) (database.ArchiveQuery, error) {
+	query := database.ArchiveQuery{
+		Username: claims.UserInfo.Username,
+		Groups:   claims.UserInfo.Groups,
+		Text:     c.Query("q"),
+		Creator:  c.Query("creator"),
+		VoteType: c.Query("type"),
+		Outcome:  c.Query("outcome"),
+		Cursor:   c.Query("cursor"),
+		Limit:    defaultArchivePageSize,
+	}
+
+	if query.VoteType != "" && query.VoteType != database.POLL_TYPE_SIMPLE && query.VoteType != database.POLL_TYPE_RANKED && query.VoteType != database.POLL_TYPE_MULTI {
+		return query, errors.New("unknown vote type")
+	}
+	if from := c.Query("from"); from != "" {
+		date, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			return query, errors.New("from must be a date like 2006-01-02")
+		}
+		query.From = date
+	}
+	if to := c.Query("to"); to != "" {
+		date, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			return query, errors.New("to must be a date like 2006-01-02")
+		}
+		// The end date is inclusive, so search up to the start of the next day
+		query.To = date.AddDate(0, 0, 1)
+	}
+	if query.Cursor != "" && !primitive.IsValidObjectID(query.Cursor) {
+		return query, errors.New("cursor must be the nextCursor from a previous page")
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxArchivePageSize {
+			return query, errors.New("limit must be between 1 and " + strconv.Itoa(maxArchivePageSize))
+		}
+		query.Limit = n
+	}
+	return query, nil
+}
+
+// nextArchivePage returns the URL of the page after this one, keeping the current filters
+func nextArchivePage(c *gin.Context, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	values := c.Request.URL.Query()
+	values.Set("cursor", cursor)
+	return c.Request.URL.Path + "?" + values.Encode()
+}
+
+func archivePage(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+	// Anyone may browse the results of closed polls, just like on the results page
+
+	query, err := parseArchiveQuery(c, claims)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	polls, cursor, err := database.SearchClosedPolls(c, query)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.HTML(200, "archive.tmpl", gin.H{
+		"Polls":    polls,
+		"Query":    c.Query("q"),
+		"Creator":  c.Query("creator"),
+		"Type":     c.Query("type"),
+		"Outcome":  c.Query("outcome"),
+		"From":     c.Query("from"),
+		"To":       c.Query("to"),
+		"NextPage": nextArchivePage(c, cursor),
+		"Username": claims.UserInfo.Username,
+		"FullName": claims.UserInfo.FullName,
+	})
+}
+
+func archiveAPI(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+
+	query, err := parseArchiveQuery(c, claims)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	polls, cursor, err := database.SearchClosedPolls(c, query)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := make([]archivePoll, 0, len(polls))
+	for _, poll := range polls {
+		results = append(results, archivePoll{
+			Id:               poll.Id,
+			ShortDescription: poll.ShortDescription,
+			LongDescription:  poll.LongDescription,
+			CreatedBy:        poll.CreatedBy,
+			CreatedAt:        poll.CreatedAt(),
+			VoteType:         poll.VoteType,
+			Outcome:          poll.Outcome,
+			Official:         poll.Official,
+		})
+	}
+
+	c.JSON(200, gin.H{
+		"polls":      results,
+		"nextCursor": cursor,
+	})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	csh_auth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// quorumStatus is how a poll stands against its quorum, for polls that have one
+type quorumStatus struct {
+	Present int64
+	Needed  int64
+	Turnout int64
+	Met     bool
+}
+
+type exportAttendee struct {
+	Member      string    `json:"member"`
+	CheckedInAt time.Time `json:"checkedInAt"`
+	MarkedBy    string    `json:"markedBy"`
+}
+
+// notifyAttendance tells anyone watching a meeting's page that its attendance has changed
+func notifyAttendance(ctx context.Context, broker *sse.Broker, meetingId string) {
+	broker.Publish(ctx, sse.NewMeetingEvent(meetingId, sse.EventAttendanceChanged, nil))
+}
+
+// isEligible reports whether a user may vote in a poll, given its attendance requirement
+func isEligible(ctx context.Context, poll *database.Poll, username string) (bool, error) {
+	if !poll.RequireAttendance || poll.MeetingId == "" {
+		return true, nil
+	}
+	return database.IsPresent(ctx, poll.MeetingId, username)
+}
+
+// getQuorumStatus counts a poll's turnout against the members present at its
+// meeting, only counting ballots from those members. It returns nil for polls
+// without a quorum
+func getQuorumStatus(ctx context.Context, poll *database.Poll) (*quorumStatus, error) {
+	if poll.MeetingId == "" || poll.Quorum <= 0 {
+		return nil, nil
+	}
+	present, err := database.CountAttendance(ctx, poll.MeetingId)
+	if err != nil {
+		return nil, err
+	}
+	turnout, err := database.CountPresentVotes(ctx, poll.Id, poll.MeetingId)
+	if err != nil {
+		return nil, err
+	}
+	needed := poll.QuorumNeeded(present)
+	return &quorumStatus{
+		Present: present,
+		Needed:  needed,
+		Turnout: turnout,
+		Met:     turnout >= needed,
+	}, nil
+}
+
+// checkIn lets a member mark themselves present at a meeting
+func checkIn(broker *sse.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cl, _ := c.Get("cshauth")
+		claims := cl.(csh_auth.CSHClaims)
+		if !canVote(claims.UserInfo.Groups) {
+			c.HTML(403, "unauthorized.tmpl", gin.H{
+				"Username": claims.UserInfo.Username,
+				"FullName": claims.UserInfo.FullName,
+			})
+			return
+		}
+
+		meeting, err := database.GetMeeting(c, c.Param("id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := database.CheckIn(c, meeting.Id, claims.UserInfo.Username, claims.UserInfo.Username); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		logAction(c, "meeting.checkin", logrus.Fields{"meeting": meeting.Id})
+		notifyAttendance(c, broker, meeting.Id)
+		c.Redirect(302, "/meeting/"+meeting.Id)
+	}
+}
+
+// markAttendance lets the chair mark members present, or remove them
+func markAttendance(broker *sse.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cl, _ := c.Get("cshauth")
+		claims := cl.(csh_auth.CSHClaims)
+
+		meeting, err := database.GetMeeting(c, c.Param("id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !meeting.CanChair(claims.UserInfo.Username) {
+			c.JSON(403, gin.H{"error": "Only the chair can take attendance"})
+			return
+		}
+
+		username := strings.TrimSpace(c.PostForm("user"))
+		if username == "" {
+			c.JSON(400, gin.H{"error": "A username is required"})
+			return
+		}
+
+		switch c.PostForm("action") {
+		case "mark":
+			err = database.CheckIn(c, meeting.Id, username, claims.UserInfo.Username)
+		case "remove":
+			err = database.CheckOut(c, meeting.Id, username)
+		default:
+			c.JSON(400, gin.H{"error": "Unknown action"})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		logAction(c, "meeting.attendance."+c.PostForm("action"), logrus.Fields{"meeting": meeting.Id, "attendee": username})
+		notifyAttendance(c, broker, meeting.Id)
+		c.Redirect(302, "/meeting/"+meeting.Id)
+	}
+}
+
+// exportAttendance serves a meeting's attendance as a CSV or JSON download
+func exportAttendance(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		c.JSON(400, gin.H{"error": "format must be csv or json"})
+		return
+	}
+
+	meeting, err := database.GetMeeting(c, c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !meeting.CanChair(claims.UserInfo.Username) {
+		c.JSON(403, gin.H{"error": "Only the chair can export attendance"})
+		return
+	}
+
+	attendance, err := database.GetAttendance(c, meeting.Id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "meeting-"+meeting.Id+"-attendance."+format))
+
+	if format == "json" {
+		attendees := make([]exportAttendee, 0, len(attendance))
+		for _, record := range attendance {
+			attendees = append(attendees, exportAttendee{
+				Member:      record.UserId,
+				CheckedInAt: record.CheckedInAt,
+				MarkedBy:    record.MarkedBy,
+			})
+		}
+		c.JSON(200, gin.H{
+			"meeting":    meeting.Title,
+			"date":       meeting.Date,
+			"attendance": attendees,
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(200)
+	w := newCSVWriter(c.Writer)
+	w.Write([]string{"Member", "Checked In At", "Marked By"})
+	for _, record := range attendance {
+		w.Write([]string{record.UserId, record.CheckedInAt.Format(time.RFC3339), record.MarkedBy})
+	}
+	w.Flush()
+}
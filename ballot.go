@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	csh_auth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/csrf"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const maxQuestions = 20
+
+// QuestionInput is a single question of the ballot builder, as the user typed it
+type QuestionInput struct {
+	ShortDescription string
+	LongDescription  string
+	VoteType         string
+	// Options holds one option per line
+	Options       string
+	AllowWriteIns bool
+	Errors        map[string]string
+}
+
+// questionResult is a question on a multi-question ballot along with its results
+type questionResult struct {
+	Question database.Question
+	Results  map[string]int
+}
+
+// parseQuestionInputs reads the numbered questions from a submitted ballot builder form.
+// Each question's fields are named after its position, like q0.shortDescription
+func parseQuestionInputs(c *gin.Context) []QuestionInput {
+	count, _ := strconv.Atoi(c.PostForm("questionCount"))
+	if count > maxQuestions+1 {
+		count = maxQuestions + 1
+	}
+
+	inputs := make([]QuestionInput, 0, count)
+	for i := 0; i < count; i++ {
+		prefix := fmt.Sprintf("q%d.", i)
+		inputs = append(inputs, QuestionInput{
+			ShortDescription: strings.TrimSpace(c.PostForm(prefix + "shortDescription")),
+			LongDescription:  strings.TrimSpace(c.PostForm(prefix + "longDescription")),
+			VoteType:         c.PostForm(prefix + "voteType"),
+			Options:          c.PostForm(prefix + "options"),
+			AllowWriteIns:    c.PostForm(prefix+"writeins") == "true",
+			Errors:           make(map[string]string),
+		})
+	}
+	return inputs
+}
+
+// validateQuestions checks every question for problems, recording them on the
+// question itself, and adds a summary to errs. It returns the questions ready
+// to be put on a ballot if there were no problems
+func validateQuestions(inputs []QuestionInput, errs map[string]string) []database.Question {
+	if len(inputs) == 0 {
+		errs["questions"] = "A ballot needs at least one question"
+		return nil
+	} else if len(inputs) > maxQuestions {
+		errs["questions"] = fmt.Sprintf("A ballot can't have more than %d questions", maxQuestions)
+		return nil
+	}
+
+	questions := make([]database.Question, 0, len(inputs))
+	for i := range inputs {
+		input := &inputs[i]
+		validateDescription(input.ShortDescription, input.Errors)
+		if input.VoteType != database.POLL_TYPE_SIMPLE && input.VoteType != database.POLL_TYPE_RANKED {
+			input.Errors["voteType"] = "Choose a vote type"
+		}
+
+		options := []OptionInput{}
+		for _, line := range strings.Split(input.Options, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				options = append(options, OptionInput{Name: line})
+			}
+		}
+		if !validateOptions(options, input.Errors) {
+			for _, option := range options {
+				if option.Error != "" {
+					input.Errors["options"] = fmt.Sprintf("%q: %s", option.Name, option.Error)
+					break
+				}
+			}
+		}
+
+		if len(input.Errors) > 0 {
+			errs["questions"] = "Some questions need fixing"
+			continue
+		}
+
+		names := make([]string, 0, len(options))
+		for _, option := range options {
+			names = append(names, option.Name)
+		}
+		questions = append(questions, database.Question{
+			Id:               strconv.Itoa(i + 1),
+			ShortDescription: input.ShortDescription,
+			LongDescription:  input.LongDescription,
+			VoteType:         input.VoteType,
+			Options:          withAbstain(input.VoteType, names),
+			AllowWriteIns:    input.AllowWriteIns,
+		})
+	}
+
+	if len(errs) > 0 {
+		return nil
+	}
+	return questions
+}
+
+// parseMultiVote reads every question's answer from a submitted ballot. Simple
+// questions must be answered, but ranked questions may be left blank
+func parseMultiVote(c *gin.Context, poll *database.Poll) (map[string]database.Answer, error) {
+	answers := make(map[string]database.Answer)
+	for _, question := range poll.Questions {
+		prefix := "q" + question.Id
+
+		if question.VoteType == database.POLL_TYPE_SIMPLE {
+			choice := c.PostForm(prefix)
+			if question.AllowWriteIns && choice == "writein" {
+				choice = strings.TrimSpace(c.PostForm(prefix + ".writein"))
+			} else if !containsString(question.Options, choice) {
+				choice = ""
+			}
+			if choice == "" {
+				return nil, fmt.Errorf("Choose an option for %q", question.ShortDescription)
+			}
+			answers[question.Id] = database.Answer{Option: choice}
+			continue
+		}
+
+		ranks := make(map[string]int)
+		for i, option := range question.Options {
+			if value := c.PostForm(fmt.Sprintf("%s.rank%d", prefix, i)); value != "" {
+				rank, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid rank for %q on %q", option, question.ShortDescription)
+				}
+				if rank > 0 {
+					ranks[option] = rank
+				}
+			}
+		}
+		if writeIn := strings.TrimSpace(c.PostForm(prefix + ".writein")); question.AllowWriteIns && writeIn != "" {
+			if value := c.PostForm(prefix + ".writeinRank"); value != "" {
+				rank, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid rank for %q on %q", writeIn, question.ShortDescription)
+				}
+				if rank > 0 {
+					ranks[writeIn] = rank
+				}
+			}
+		}
+		if len(ranks) > 0 {
+			answers[question.Id] = database.Answer{Options: ranks}
+		}
+	}
+	return answers, nil
+}
+
+// getQuestionResults pairs each question on a ballot with its results, in ballot order
+func getQuestionResults(ctx context.Context, poll *database.Poll) ([]questionResult, error) {
+	results, err := poll.GetQuestionResults(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	questions := make([]questionResult, 0, len(poll.Questions))
+	for _, question := range poll.Questions {
+		questions = append(questions, questionResult{Question: question, Results: results[question.Id]})
+	}
+	return questions, nil
+}
+
+func ballotPage(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+	if !canVote(claims.UserInfo.Groups) {
+		c.HTML(403, "unauthorized.tmpl", gin.H{
+			"Username": claims.UserInfo.Username,
+			"FullName": claims.UserInfo.FullName,
+		})
+		return
+	}
+
+	c.HTML(200, "ballot_create.tmpl", gin.H{
+		"Questions":       []QuestionInput{{VoteType: database.POLL_TYPE_SIMPLE}},
+		"CanMarkOfficial": canMarkOfficial(claims.UserInfo.Groups),
+		"Username":        claims.UserInfo.Username,
+		"FullName":        claims.UserInfo.FullName,
+		"CSRFToken":       csrf.Token(c),
+	})
+}
+
+func createBallot(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+	if !canVote(claims.UserInfo.Groups) {
+		c.HTML(403, "unauthorized.tmpl", gin.H{
+			"Username": claims.UserInfo.Username,
+			"FullName": claims.UserInfo.FullName,
+		})
+		return
+	}
+
+	poll := &database.Poll{
+		CreatedBy:        claims.UserInfo.Username,
+		ShortDescription: strings.TrimSpace(c.PostForm("shortDescription")),
+		LongDescription:  strings.TrimSpace(c.PostForm("longDescription")),
+		VoteType:         database.POLL_TYPE_MULTI,
+		Options:          []string{},
+		Open:             true,
+		Hidden:           false,
+		Official:         c.PostForm("official") == "true" && canMarkOfficial(claims.UserInfo.Groups),
+	}
+
+	errs := make(map[string]string)
+	validateDescription(poll.ShortDescription, errs)
+	inputs := parseQuestionInputs(c)
+	poll.Questions = validateQuestions(inputs, errs)
+
+	if len(errs) > 0 {
+		if len(inputs) == 0 {
+			inputs = []QuestionInput{{VoteType: database.POLL_TYPE_SIMPLE}}
+		}
+		c.HTML(400, "ballot_create.tmpl", gin.H{
+			"Errors":           errs,
+			"ShortDescription": poll.ShortDescription,
+			"LongDescription":  poll.LongDescription,
+			"Questions":        inputs,
+			"Official":         poll.Official,
+			"CanMarkOfficial":  canMarkOfficial(claims.UserInfo.Groups),
+			"Username":         claims.UserInfo.Username,
+			"FullName":         claims.UserInfo.FullName,
+			"CSRFToken":        csrf.Token(c),
+		})
+		return
+	}
+
+	pollId, err := database.CreatePoll(c, poll)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	logAction(c, "poll.create", logrus.Fields{"poll": pollId, "questions": len(poll.Questions)})
+	c.Redirect(302, "/poll/"+pollId)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	csh_auth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/blt"
+	"github.com/computersciencehouse/vote/csrf"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxBLTFileSize = 1 << 20
+	// Each unit of ballot weight becomes a vote, so this bounds the size of an import
+	maxBLTBallots = 10000
+)
+
+// pollToElection converts a ranked poll's ballots to a BLT election. Identical
+// ballots are combined into one with a higher weight, so nothing about the
+// order they were cast in is kept
+func pollToElection(poll *database.Poll, ballots []database.Ballot) *blt.Election {
+	election := &blt.Election{
+		Title:      poll.ShortDescription,
+		Seats:      1,
+		Candidates: append([]string{}, poll.Options...),
+	}
+
+	// Write-ins become candidates after the poll's own options
+	writeIns := []string{}
+	for _, ballot := range ballots {
+		for option := range ballot.Options {
+			if !containsString(election.Candidates, option) && !containsString(writeIns, option) {
+				writeIns = append(writeIns, option)
+			}
+		}
+	}
+	sort.Strings(writeIns)
+	election.Candidates = append(election.Candidates, writeIns...)
+
+	index := make(map[string]int)
+	for i, candidate := range election.Candidates {
+		index[candidate] = i
+	}
+
+	weights := make(map[string]int)
+	preferences := make(map[string][][]int)
+	for _, ballot := range ballots {
+		options := make([]string, 0, len(ballot.Options))
+		for option := range ballot.Options {
+			options = append(options, option)
+		}
+		sort.Slice(options, func(i, j int) bool {
+			if ballot.Options[options[i]] != ballot.Options[options[j]] {
+				return ballot.Options[options[i]] < ballot.Options[options[j]]
+			}
+			return index[options[i]] < index[options[j]]
+		})
+
+		// Options given the same rank are grouped together as equal preferences
+		var groups [][]int
+		for i, option := range options {
+			if i > 0 && ballot.Options[option] == ballot.Options[options[i-1]] {
+				groups[len(groups)-1] = append(groups[len(groups)-1], index[option])
+			} else {
+				groups = append(groups, []int{index[option]})
+			}
+		}
+		if len(groups) == 0 {
+			continue
+		}
+
+		key := fmt.Sprint(groups)
+		if _, ok := weights[key]; !ok {
+			preferences[key] = groups
+		}
+		weights[key]++
+	}
+
+	keys := make([]string, 0, len(weights))
+	for key := range weights {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		election.Ballots = append(election.Ballots, blt.Ballot{Weight: weights[key], Preferences: preferences[key]})
+	}
+
+	return election
+}
+
+// electionToVotes converts a BLT election's ballots to ranked votes, leaving
+// out withdrawn candidates
+func electionToVotes(election *blt.Election) ([]string, []database.RankedVote, error) {
+	options := []string{}
+	for i, candidate := range election.Candidates {
+		if !containsInt(election.Withdrawn, i) {
+			options = append(options, candidate)
+		}
+	}
+
+	total := 0
+	for _, ballot := range election.Ballots {
+		total += ballot.Weight
+		if ballot.Weight > maxBLTBallots || total > maxBLTBallots {
+			return nil, nil, fmt.Errorf("a file can contain at most %d ballots", maxBLTBallots)
+		}
+	}
+
+	votes := make([]database.RankedVote, 0, total)
+	for _, ballot := range election.Ballots {
+		ranks := make(map[string]int)
+		rank := 0
+		for _, group := range ballot.Preferences {
+			counted := false
+			for _, c := range group {
+				if containsInt(election.Withdrawn, c) {
+					continue
+				}
+				if !counted {
+					rank++
+					counted = true
+				}
+				if _, ok := ranks[election.Candidates[c]]; !ok {
+					ranks[election.Candidates[c]] = rank
+				}
+			}
+		}
+		for i := 0; i < ballot.Weight; i++ {
+			votes = append(votes, database.RankedVote{
+				UserId:  fmt.Sprintf("blt-%d", len(votes)+1),
+				Options: ranks,
+			})
+		}
+	}
+
+	return options, votes, nil
+}
+
+func exportBLT(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+
+	poll, err := database.GetPoll(c, c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !poll.CanManage(claims.UserInfo.Username, claims.UserInfo.Groups) || poll.Open {
+		c.JSON(403, gin.H{"error": "Only the creator or a manager can export ballots, once the poll is closed"})
+		return
+	}
+	if poll.VoteType != database.POLL_TYPE_RANKED {
+		c.JSON(400, gin.H{"error": "Only ranked choice polls can be exported as BLT"})
+		return
+	}
+
+	ballots, err := database.GetBallots(c, poll.Id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Encoded up front so a ballot that can't be written is an error, not a truncated file
+	var buf bytes.Buffer
+	if err := blt.Write(&buf, pollToElection(poll, ballots)); err != nil {
+		logging.FromContext(c).WithFields(logrus.Fields{"error": err, "module": "blt", "method": "exportBLT", "poll": poll.Id}).Error("error writing blt export")
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "poll-"+poll.Id+".blt"))
+	c.Data(200, "text/plain; charset=utf-8", buf.Bytes())
+}
+
+func importBLTPage(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+	if !canVote(claims.UserInfo.Groups) {
+		c.HTML(403, "unauthorized.tmpl", gin.H{
+			"Username": claims.UserInfo.Username,
+			"FullName": claims.UserInfo.FullName,
+		})
+		return
+	}
+
+	c.HTML(200, "import.tmpl", gin.H{
+		"Username":  claims.UserInfo.Username,
+		"FullName":  claims.UserInfo.FullName,
+		"CSRFToken": csrf.Token(c),
+	})
+}
+
+func importBLT(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+	if !canVote(claims.UserInfo.Groups) {
+		c.HTML(403, "unauthorized.tmpl", gin.H{
+			"Username": claims.UserInfo.Username,
+			"FullName": claims.UserInfo.FullName,
+		})
+		return
+	}
+
+	renderError := func(message string) {
+		c.HTML(400, "import.tmpl", gin.H{
+			"Error":            message,
+			"ShortDescription": c.PostForm("shortDescription"),
+			"LongDescription":  c.PostForm("longDescription"),
+			"Username":         claims.UserInfo.Username,
+			"FullName":         claims.UserInfo.FullName,
+			"CSRFToken":        csrf.Token(c),
+		})
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		renderError("Choose a BLT file to import")
+		return
+	}
+	if header.Size > maxBLTFileSize {
+		renderError("BLT files can't be larger than 1 MB")
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	election, err := blt.Read(file)
+	if err != nil {
+		renderError("Couldn't read the BLT file: " + err.Error())
+		return
+	}
+	if election.Seats != 1 {
+		renderError("Only single winner elections can be imported")
+		return
+	}
+
+	options, votes, err := electionToVotes(election)
+	if err != nil {
+		renderError(err.Error())
+		return
+	}
+
+	poll := &database.Poll{
+		CreatedBy:        claims.UserInfo.Username,
+		ShortDescription: strings.TrimSpace(c.PostForm("shortDescription")),
+		LongDescription:  strings.TrimSpace(c.PostForm("longDescription")),
+		VoteType:         database.POLL_TYPE_RANKED,
+		Open:             false,
+		History: []database.HistoryEntry{{
+			Time:    time.Now(),
+			User:    claims.UserInfo.Username,
+			Action:  "import",
+			Details: fmt.Sprintf("imported %d ballots from %s", len(votes), header.Filename),
+		}},
+	}
+	if poll.ShortDescription == "" {
+		poll.ShortDescription = election.Title
+	}
+
+	errs := make(map[string]string)
+	validateDescription(poll.ShortDescription, errs)
+	if message, ok := errs["shortDescription"]; ok {
+		renderError(message)
+		return
+	}
+	inputs := make([]OptionInput, 0, len(options))
+	for _, option := range options {
+		inputs = append(inputs, OptionInput{Name: option})
+	}
+	if !validateOptions(inputs, errs) {
+		message := errs["options"]
+		for _, input := range inputs {
+			if input.Error != "" {
+				message = fmt.Sprintf("Candidate %q: %s", input.Name, input.Error)
+				break
+			}
+		}
+		renderError(message)
+		return
+	}
+	applyOptions(poll, inputs)
+
+	pollId, err := database.CreatePoll(c, poll)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	poll.Id = pollId
+
+	pId, _ := primitive.ObjectIDFromHex(pollId)
+	for i := range votes {
+		votes[i].PollId = pId
+	}
+	if err := database.CastRankedVotes(c, votes); err != nil {
+		database.DeletePoll(c, pollId)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Closing the poll tabulates the imported ballots and records the outcome
+	if err := poll.Close(c); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	logAction(c, "poll.import", logrus.Fields{"poll": pollId, "ballots": len(votes)})
+	c.Redirect(302, "/results/"+pollId)
+}
+
+func containsInt(arr []int, val int) bool {
+	for _, a := range arr {
+		if a == val {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+// Package blt reads and writes ranked ballots in the BLT format used by
+// OpenSTV and other election counting tools.
+//
+// A BLT file starts with the number of candidates and seats, optionally
+// followed by withdrawn candidates as negative numbers. Each ballot is a
+// weight, the candidates in order of preference (numbered from 1, with
+// equally ranked candidates joined by "="), and a terminating 0. A lone 0
+// ends the ballots, and is followed by the quoted candidate names and the
+// quoted title of the election. Quoted strings are escaped like Go string
+// literals.
+package blt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type Election struct {
+	Title      string
+	Seats      int
+	Candidates []string
+	// Withdrawn holds the indices of candidates that should be ignored
+	Withdrawn []int
+	Ballots   []Ballot
+}
+
+type Ballot struct {
+	Weight int
+	// Preferences holds groups of candidate indices, most preferred first.
+	// Candidates in the same group are ranked equally
+	Preferences [][]int
+}
+
+// Write encodes an election in BLT format
+func Write(w io.Writer, election *Election) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "%d %d\n", len(election.Candidates), election.Seats)
+	if len(election.Withdrawn) > 0 {
+		for i, c := range election.Withdrawn {
+			if i > 0 {
+				bw.WriteString(" ")
+			}
+			fmt.Fprintf(bw, "-%d", c+1)
+		}
+		bw.WriteString("\n")
+	}
+
+	for _, ballot := range election.Ballots {
+		fmt.Fprintf(bw, "%d", ballot.Weight)
+		for _, group := range ballot.Preferences {
+			names := make([]string, 0, len(group))
+			for _, c := range group {
+				if c < 0 || c >= len(election.Candidates) {
+					return fmt.Errorf("ballot ranks unknown candidate %d", c+1)
+				}
+				names = append(names, strconv.Itoa(c+1))
+			}
+			bw.WriteString(" " + strings.Join(names, "="))
+		}
+		bw.WriteString(" 0\n")
+	}
+	bw.WriteString("0\n")
+
+	for _, candidate := range election.Candidates {
+		bw.WriteString(strconv.Quote(candidate) + "\n")
+	}
+	bw.WriteString(strconv.Quote(election.Title) + "\n")
+
+	return bw.Flush()
+}
+
+// Read decodes an election in BLT format
+func Read(r io.Reader) (*Election, error) {
+	tokens, err := tokenize(r)
+	if err != nil {
+		return nil, err
+	}
+	next := func() (token, error) {
+		if len(tokens) == 0 {
+			return token{}, errors.New("unexpected end of file")
+		}
+		t := tokens[0]
+		tokens = tokens[1:]
+		return t, nil
+	}
+	nextInt := func(what string) (int, error) {
+		t, err := next()
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.Atoi(t.text)
+		if t.quoted || err != nil {
+			return 0, fmt.Errorf("line %d: expected %s, found %q", t.line, what, t.text)
+		}
+		return n, nil
+	}
+
+	election := &Election{}
+	candidates, err := nextInt("number of candidates")
+	if err != nil {
+		return nil, err
+	}
+	if candidates < 1 {
+		return nil, errors.New("an election needs at least one candidate")
+	}
+	if election.Seats, err = nextInt("number of seats"); err != nil {
+		return nil, err
+	}
+
+	for {
+		n, err := nextInt("ballot weight")
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		if n < 0 {
+			if -n > candidates {
+				return nil, fmt.Errorf("withdrawn candidate %d does not exist", -n)
+			}
+			election.Withdrawn = append(election.Withdrawn, -n-1)
+			continue
+		}
+
+		ballot := Ballot{Weight: n}
+		for {
+			t, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if t.text == "0" && !t.quoted {
+				break
+			}
+			group := []int{}
+			for _, part := range strings.Split(t.text, "=") {
+				c, err := strconv.Atoi(part)
+				if t.quoted || err != nil || c < 1 || c > candidates {
+					return nil, fmt.Errorf("line %d: expected a candidate, found %q", t.line, t.text)
+				}
+				group = append(group, c-1)
+			}
+			ballot.Preferences = append(ballot.Preferences, group)
+		}
+		election.Ballots = append(election.Ballots, ballot)
+	}
+
+	for i := 0; i < candidates; i++ {
+		t, err := next()
+		if err != nil {
+			return nil, err
+		}
+		election.Candidates = append(election.Candidates, t.text)
+	}
+	if t, err := next(); err == nil {
+		election.Title = t.text
+	}
+
+	return election, nil
+}
+
+type token struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+// tokenize splits a BLT file into whitespace separated words and quoted strings
+func tokenize(r io.Reader) ([]token, error) {
+	var tokens []token
+	br := bufio.NewReader(r)
+	line := 1
+	for {
+		ch, _, err := br.ReadRune()
+		if err == io.EOF {
+			return tokens, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case ch == '\n':
+			line++
+		case unicode.IsSpace(ch):
+		case ch == '"':
+			// Strings are unquoted the way Write quotes them, as Go string
+			// literals, although a line break doesn't have to be escaped
+			var sb strings.Builder
+			sb.WriteRune(ch)
+			start := line
+			for {
+				ch, _, err := br.ReadRune()
+				if err == io.EOF {
+					return nil, fmt.Errorf("line %d: unterminated string", start)
+				} else if err != nil {
+					return nil, err
+				}
+				if ch == '"' {
+					sb.WriteRune(ch)
+					break
+				}
+				if ch == '\\' {
+					sb.WriteRune(ch)
+					if ch, _, err = br.ReadRune(); err != nil {
+						return nil, fmt.Errorf("line %d: unterminated string", start)
+					}
+				}
+				if ch == '\n' {
+					line++
+					sb.WriteString(`\n`)
+					continue
+				}
+				sb.WriteRune(ch)
+			}
+			text, err := strconv.Unquote(sb.String())
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid string %s", start, sb.String())
+			}
+			tokens = append(tokens, token{text: text, quoted: true, line: start})
+		default:
+			var sb strings.Builder
+			sb.WriteRune(ch)
+			for {
+				ch, _, err := br.ReadRune()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return nil, err
+				}
+				if unicode.IsSpace(ch) || ch == '"' {
+					br.UnreadRune()
+					break
+				}
+				sb.WriteRune(ch)
+			}
+			tokens = append(tokens, token{text: sb.String(), line: line})
+		}
+	}
+}
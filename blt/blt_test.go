@@ -0,0 +1,197 @@
+package blt
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *Election
+		wantErr string
+	}{
+		{
+			name: "ballots, names and title",
+			input: `3 1
+1 1 2 3 0
+2 2 0
+0
+"Alice"
+"Bob"
+"Carol"
+"Chair Election"
+`,
+			want: &Election{
+				Title:      "Chair Election",
+				Seats:      1,
+				Candidates: []string{"Alice", "Bob", "Carol"},
+				Ballots: []Ballot{
+					{Weight: 1, Preferences: [][]int{{0}, {1}, {2}}},
+					{Weight: 2, Preferences: [][]int{{1}}},
+				},
+			},
+		},
+		{
+			name:  "withdrawn candidates",
+			input: "3 1\n-2 -3\n1 1 2 0\n0\n\"Alice\" \"Bob\" \"Carol\" \"Election\"\n",
+			want: &Election{
+				Title:      "Election",
+				Seats:      1,
+				Candidates: []string{"Alice", "Bob", "Carol"},
+				Withdrawn:  []int{1, 2},
+				Ballots:    []Ballot{{Weight: 1, Preferences: [][]int{{0}, {1}}}},
+			},
+		},
+		{
+			name:  "equally ranked candidates",
+			input: "3 1\n1 2=3 1 0\n0\n\"Alice\" \"Bob\" \"Carol\" \"Election\"\n",
+			want: &Election{
+				Title:      "Election",
+				Seats:      1,
+				Candidates: []string{"Alice", "Bob", "Carol"},
+				Ballots:    []Ballot{{Weight: 1, Preferences: [][]int{{1, 2}, {0}}}},
+			},
+		},
+		{
+			name:  "quoted names with escapes and spaces",
+			input: "2 1\n0\n\"O\\\"Brien\" \"Mary\nAnne\"\n",
+			want: &Election{
+				Seats:      1,
+				Candidates: []string{`O"Brien`, "Mary\nAnne"},
+			},
+		},
+		{
+			name:  "escapes like Go strings",
+			input: "3 1\n0\n\"Tab\\there\" \"Zo\\u00eb\" \"C:\\\\Users\" \"Election\"\n",
+			want: &Election{
+				Title:      "Election",
+				Seats:      1,
+				Candidates: []string{"Tab\there", "Zoë", `C:\Users`},
+			},
+		},
+		{
+			name:  "missing title",
+			input: "1 1\n1 1 0\n0\n\"Alice\"\n",
+			want: &Election{
+				Seats:      1,
+				Candidates: []string{"Alice"},
+				Ballots:    []Ballot{{Weight: 1, Preferences: [][]int{{0}}}},
+			},
+		},
+		{
+			name:    "ballot missing its terminating 0",
+			input:   "2 1\n1 1 2\n\"Alice\" \"Bob\" \"Election\"\n",
+			wantErr: `line 3: expected a candidate, found "Alice"`,
+		},
+		{
+			name:    "ballots missing the closing 0",
+			input:   "2 1\n1 1 2 0\n\"Alice\" \"Bob\" \"Election\"\n",
+			wantErr: `line 3: expected ballot weight, found "Alice"`,
+		},
+		{
+			name:    "file ends inside a ballot",
+			input:   "2 1\n1 1 2",
+			wantErr: "unexpected end of file",
+		},
+		{
+			name:    "number of candidates isn't a number",
+			input:   "three 1\n0\n",
+			wantErr: `line 1: expected number of candidates, found "three"`,
+		},
+		{
+			name:    "no candidates",
+			input:   "0 1\n0\n",
+			wantErr: "an election needs at least one candidate",
+		},
+		{
+			name:    "number of seats missing",
+			input:   "2",
+			wantErr: "unexpected end of file",
+		},
+		{
+			name:    "candidate out of range",
+			input:   "2 1\n1 3 0\n0\n\"Alice\" \"Bob\"\n",
+			wantErr: `line 2: expected a candidate, found "3"`,
+		},
+		{
+			name:    "withdrawn candidate out of range",
+			input:   "2 1\n-3\n0\n\"Alice\" \"Bob\"\n",
+			wantErr: "withdrawn candidate 3 does not exist",
+		},
+		{
+			name:    "fewer names than candidates",
+			input:   "3 1\n0\n\"Alice\" \"Bob\"\n",
+			wantErr: "unexpected end of file",
+		},
+		{
+			name:    "unknown escape",
+			input:   "1 1\n0\n\"Al\\ice\"\n",
+			wantErr: `line 3: invalid string "Al\ice"`,
+		},
+		{
+			name:    "unterminated name",
+			input:   "1 1\n0\n\"Alice\n",
+			wantErr: "line 3: unterminated string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Read(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Read() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Read() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	election := &Election{
+		Title:      `The "Big" Election`,
+		Seats:      2,
+		Candidates: []string{"Alice", "Bob\tBobby", "Zoë", "Dave\\Dee\x00"},
+		Withdrawn:  []int{3},
+		Ballots: []Ballot{
+			{Weight: 3, Preferences: [][]int{{0}, {1}, {2}}},
+			{Weight: 1, Preferences: [][]int{{1, 2}, {0}}},
+			{Weight: 2, Preferences: [][]int{{2}}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, election); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, election) {
+		t.Errorf("Read(Write()) = %+v, want %+v", got, election)
+	}
+}
+
+func TestWriteUnknownCandidate(t *testing.T) {
+	election := &Election{
+		Seats:      1,
+		Candidates: []string{"Alice"},
+		Ballots:    []Ballot{{Weight: 1, Preferences: [][]int{{1}}}},
+	}
+
+	if err := Write(&bytes.Buffer{}, election); err == nil {
+		t.Error("Write() accepted a ballot ranking a candidate that doesn't exist")
+	}
+}
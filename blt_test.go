@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/computersciencehouse/vote/blt"
+)
+
+func TestElectionToVotesEqualRanks(t *testing.T) {
+	election, err := blt.Read(strings.NewReader("3 1\n-3\n2 1=2 3 0\n1 3 2 0\n0\n\"A\"\n\"B\"\n\"C\"\n\"Title\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, votes, err := electionToVotes(election)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"A", "B"}; !reflect.DeepEqual(options, want) {
+		t.Errorf("options = %q, want %q", options, want)
+	}
+
+	want := []map[string]int{{"A": 1, "B": 1}, {"A": 1, "B": 1}, {"B": 1}}
+	var got []map[string]int
+	for _, vote := range votes {
+		got = append(got, vote.Options)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rankings = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,355 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/computersciencehouse/vote/sse"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds every setting the app needs to start. Settings are read from
+// a YAML file, then the environment, then command line flags, each overriding
+// the one before
+type Config struct {
+	// Addr is the address the server listens on
+	Addr string `yaml:"addr"`
+	// Host is the public URL of the app, used for OIDC redirects
+	Host string `yaml:"host"`
+	// ShutdownTimeout is how long requests in flight get to finish once the server is stopping
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// ReadHeaderTimeout is how long a client gets to send a request's headers
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+
+	Log      Log      `yaml:"log"`
+	Metrics  Metrics  `yaml:"metrics"`
+	Tracing  Tracing  `yaml:"tracing"`
+	Database Database `yaml:"database"`
+	OIDC     OIDC     `yaml:"oidc"`
+	Groups   Groups   `yaml:"groups"`
+	SSE      SSE      `yaml:"sse"`
+}
+
+type Log struct {
+	// Level is the least severe level logged, like debug or info
+	Level string `yaml:"level"`
+	// Format is json, or text for reading in a terminal
+	Format string `yaml:"format"`
+}
+
+type Metrics struct {
+	// Token must be sent as a bearer token to read /metrics, unless it's empty
+	Token string `yaml:"token"`
+}
+
+type Tracing struct {
+	// Exporter is none, stdout for trying tracing out locally, or otlp
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the URL of an OTLP/HTTP collector, like http://localhost:4318.
+	// Without it, the standard OTEL_EXPORTER_OTLP_* variables are used
+	Endpoint string `yaml:"endpoint"`
+}
+
+type Database struct {
+	URI  string `yaml:"uri"`
+	Name string `yaml:"name"`
+	// Timeout limits every database operation
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type OIDC struct {
+	ClientId     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret"`
+	JWTSecret    string `yaml:"jwtSecret"`
+	State        string `yaml:"state"`
+}
+
+// Groups are the OIDC groups that decide what members may do
+type Groups struct {
+	// Admin members may override any poll
+	Admin []string `yaml:"admin"`
+	// Official members may mark polls as official
+	Official []string `yaml:"official"`
+	// Active members may vote, unless they're on co-op for the semester
+	Active     string `yaml:"active"`
+	FallCoop   string `yaml:"fallCoop"`
+	SpringCoop string `yaml:"springCoop"`
+}
+
+type SSE struct {
+	QueueSize   int                `yaml:"queueSize"`
+	Overflow    sse.OverflowPolicy `yaml:"overflow"`
+	HistorySize int                `yaml:"historySize"`
+	Heartbeat   time.Duration      `yaml:"heartbeat"`
+	// PubSub is memory, or mongo to share events between instances
+	PubSub string `yaml:"pubsub"`
+}
+
+// Default is the configuration before anything is loaded. Settings without a
+// sensible default, like secrets, are left empty and must be set
+var Default = Config{
+	Addr:              ":8080",
+	ShutdownTimeout:   30 * time.Second,
+	ReadHeaderTimeout: 10 * time.Second,
+	Log: Log{
+		Level:  "info",
+		Format: "json",
+	},
+	Tracing: Tracing{
+		Exporter: "none",
+	},
+	Database: Database{
+		Name:    "vote",
+		Timeout: 10 * time.Second,
+	},
+	OIDC: OIDC{
+		ClientId: "vote",
+	},
+	Groups: Groups{
+		Admin:      []string{"eboard", "rtp"},
+		Official:   []string{"eboard"},
+		Active:     "active",
+		FallCoop:   "fall_coop",
+		SpringCoop: "spring_coop",
+	},
+	SSE: SSE{
+		QueueSize:   sse.DefaultConfig.QueueSize,
+		Overflow:    sse.DefaultConfig.Overflow,
+		HistorySize: sse.DefaultConfig.HistorySize,
+		Heartbeat:   sse.DefaultConfig.Heartbeat,
+		PubSub:      "memory",
+	},
+}
+
+// setting is one value that can be set from the environment or a flag. The
+// flag is named after the setting's key in the config file
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	// optional strings may be left empty
+	optional bool
+	value    interface{}
+}
+
+func (config *Config) settings() []setting {
+	return []setting{
+		{key: "addr", env: "VOTE_ADDR", usage: "address to listen on", value: &config.Addr},
+		{key: "host", env: "VOTE_HOST", usage: "public URL of the app", value: &config.Host},
+		{key: "shutdownTimeout", env: "VOTE_SHUTDOWN_TIMEOUT", usage: "how long requests get to finish when stopping", value: &config.ShutdownTimeout},
+		{key: "readHeaderTimeout", env: "VOTE_READ_HEADER_TIMEOUT", usage: "how long clients get to send request headers", value: &config.ReadHeaderTimeout},
+		{key: "log.level", env: "VOTE_LOG_LEVEL", usage: "least severe level to log: debug, info, warn or error", value: &config.Log.Level},
+		{key: "log.format", env: "VOTE_LOG_FORMAT", usage: "log format: json or text", value: &config.Log.Format},
+		{key: "metrics.token", env: "VOTE_METRICS_TOKEN", usage: "bearer token required to read /metrics, if any", secret: true, optional: true, value: &config.Metrics.Token},
+		{key: "tracing.exporter", env: "VOTE_TRACING_EXPORTER", usage: "where spans are sent: none, stdout or otlp", value: &config.Tracing.Exporter},
+		{key: "tracing.endpoint", env: "VOTE_TRACING_ENDPOINT", usage: "URL of the OTLP/HTTP collector, if not the default", optional: true, value: &config.Tracing.Endpoint},
+		{key: "database.uri", env: "VOTE_MONGODB_URI", usage: "MongoDB connection string", secret: true, value: &config.Database.URI},
+		{key: "database.name", env: "VOTE_MONGODB_NAME", usage: "MongoDB database name", value: &config.Database.Name},
+		{key: "database.timeout", env: "VOTE_MONGODB_TIMEOUT", usage: "timeout for each database operation", value: &config.Database.Timeout},
+		{key: "oidc.clientId", env: "VOTE_OIDC_ID", usage: "OIDC client id", value: &config.OIDC.ClientId},
+		{key: "oidc.clientSecret", env: "VOTE_OIDC_SECRET", usage: "OIDC client secret", secret: true, value: &config.OIDC.ClientSecret},
+		{key: "oidc.jwtSecret", env: "VOTE_JWT_SECRET", usage: "secret used to sign session tokens", secret: true, value: &config.OIDC.JWTSecret},
+		{key: "oidc.state", env: "VOTE_STATE", usage: "OIDC state parameter", secret: true, value: &config.OIDC.State},
+		{key: "groups.admin", env: "VOTE_ADMIN_GROUPS", usage: "comma-separated groups that may override any poll", value: &config.Groups.Admin},
+		{key: "groups.official", env: "VOTE_OFFICIAL_GROUPS", usage: "comma-separated groups that may mark polls as official", value: &config.Groups.Official},
+		{key: "groups.active", env: "VOTE_ACTIVE_GROUP", usage: "group of members who may vote", value: &config.Groups.Active},
+		{key: "groups.fallCoop", env: "VOTE_FALL_COOP_GROUP", usage: "group of members on co-op in the fall", optional: true, value: &config.Groups.FallCoop},
+		{key: "groups.springCoop", env: "VOTE_SPRING_COOP_GROUP", usage: "group of members on co-op in the spring", optional: true, value: &config.Groups.SpringCoop},
+		{key: "sse.queueSize", env: "VOTE_SSE_QUEUE_SIZE", usage: "events queued for each live client", value: &config.SSE.QueueSize},
+		{key: "sse.overflow", env: "VOTE_SSE_OVERFLOW", usage: "what to do when a client's queue is full: coalesce, drop-oldest or disconnect", value: &config.SSE.Overflow},
+		{key: "sse.historySize", env: "VOTE_SSE_HISTORY_SIZE", usage: "events kept per topic for reconnecting clients", value: &config.SSE.HistorySize},
+		{key: "sse.heartbeat", env: "VOTE_SSE_HEARTBEAT", usage: "how often idle streams get a heartbeat", value: &config.SSE.Heartbeat},
+		{key: "sse.pubsub", env: "VOTE_PUBSUB", usage: "how events reach other instances: memory or mongo", value: &config.SSE.PubSub},
+	}
+}
+
+// set parses value into the field a setting points at
+func (s setting) set(value string) error {
+	switch field := s.value.(type) {
+	case *string:
+		*field = value
+	case *sse.OverflowPolicy:
+		*field = sse.OverflowPolicy(value)
+	case *int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a whole number, not %q", s.key, value)
+		}
+		*field = number
+	case *time.Duration:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a duration like 10s, not %q", s.key, value)
+		}
+		*field = duration
+	case *[]string:
+		*field = strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+	}
+	return nil
+}
+
+// Load reads the configuration from the file named by --config or VOTE_CONFIG,
+// if any, then the environment, then the command line arguments. It reports
+// whether --print-config was passed. The result still needs to be validated
+func Load(args []string) (*Config, bool, error) {
+	config := Default
+	settings := config.settings()
+
+	flags := flag.NewFlagSet("vote", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv("VOTE_CONFIG"), "YAML file to read the configuration from (env VOTE_CONFIG)")
+	printConfig := flags.Bool("print-config", false, "print the configuration, with secrets redacted, and exit")
+	// Flags are applied last, after the file and environment they may override
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		key := s.key
+		flags.Func(key, fmt.Sprintf("%s (env %s)", s.usage, s.env), func(value string) error {
+			flagValues[key] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	if *path != "" {
+		contents, err := os.ReadFile(*path)
+		if err != nil {
+			return nil, false, fmt.Errorf("couldn't read config file: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(contents, &config); err != nil {
+			return nil, false, fmt.Errorf("couldn't parse config file %s: %w", *path, err)
+		}
+	}
+
+	// PORT is what most hosting platforms set, so it's honoured unless the
+	// address is set more specifically
+	if port := os.Getenv("PORT"); port != "" && os.Getenv("VOTE_ADDR") == "" {
+		config.Addr = ":" + port
+	}
+
+	var problems Problems
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			if err := s.set(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s (from %s)", err, s.env))
+			}
+		}
+		if value, ok := flagValues[s.key]; ok {
+			if err := s.set(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s (from --%s)", err, s.key))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, false, problems
+	}
+	return &config, *printConfig, nil
+}
+
+// Problems lists everything wrong with a configuration, so they can all be
+// fixed at once
+type Problems []string
+
+func (problems Problems) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(problems, "\n  - ")
+}
+
+// Validate checks that every setting is present and makes sense
+func (config *Config) Validate() error {
+	var problems Problems
+	for _, s := range config.settings() {
+		if value, ok := s.value.(*string); ok && *value == "" && !s.optional {
+			problems = append(problems, fmt.Sprintf("%s is required; set %s or --%s", s.key, s.env, s.key))
+		}
+	}
+
+	if config.Host != "" {
+		if host, err := url.Parse(config.Host); err != nil || (host.Scheme != "http" && host.Scheme != "https") || host.Host == "" {
+			problems = append(problems, fmt.Sprintf("host must be a URL like https://vote.csh.rit.edu, not %q", config.Host))
+		}
+	}
+	if _, err := logrus.ParseLevel(config.Log.Level); config.Log.Level != "" && err != nil {
+		problems = append(problems, fmt.Sprintf("log.level must be a level like debug or info, not %q", config.Log.Level))
+	}
+	if format := config.Log.Format; format != "" && format != "json" && format != "text" {
+		problems = append(problems, fmt.Sprintf("log.format must be json or text, not %q", format))
+	}
+	if exporter := config.Tracing.Exporter; exporter != "" && exporter != "none" && exporter != "stdout" && exporter != "otlp" {
+		problems = append(problems, fmt.Sprintf("tracing.exporter must be none, stdout or otlp, not %q", exporter))
+	}
+	if config.Tracing.Endpoint != "" {
+		if endpoint, err := url.Parse(config.Tracing.Endpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			problems = append(problems, fmt.Sprintf("tracing.endpoint must be a URL like http://localhost:4318, not %q", config.Tracing.Endpoint))
+		}
+	}
+	if config.Database.URI != "" && !strings.HasPrefix(config.Database.URI, "mongodb://") && !strings.HasPrefix(config.Database.URI, "mongodb+srv://") {
+		problems = append(problems, "database.uri must start with mongodb:// or mongodb+srv://")
+	}
+	if _, err := sse.ParseOverflowPolicy(string(config.SSE.Overflow)); config.SSE.Overflow != "" && err != nil {
+		problems = append(problems, fmt.Sprintf("sse.overflow must be coalesce, drop-oldest or disconnect, not %q", config.SSE.Overflow))
+	}
+	if pubsub := config.SSE.PubSub; pubsub != "" && pubsub != "memory" && pubsub != "mongo" {
+		problems = append(problems, fmt.Sprintf("sse.pubsub must be memory or mongo, not %q", pubsub))
+	}
+
+	positive := map[string]bool{
+		"shutdownTimeout":   config.ShutdownTimeout > 0,
+		"readHeaderTimeout": config.ReadHeaderTimeout > 0,
+		"database.timeout":  config.Database.Timeout > 0,
+		"sse.queueSize":     config.SSE.QueueSize > 0,
+		"sse.historySize":   config.SSE.HistorySize > 0,
+		"sse.heartbeat":     config.SSE.Heartbeat > 0,
+	}
+	for _, s := range config.settings() {
+		if ok, checked := positive[s.key]; checked && !ok {
+			problems = append(problems, fmt.Sprintf("%s must be greater than zero", s.key))
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration that's safe to print, with
+// every secret hidden. Only the password is hidden from the database URI
+func (config Config) Redacted() Config {
+	for _, s := range config.settings() {
+		value, ok := s.value.(*string)
+		if !s.secret || !ok || *value == "" {
+			continue
+		}
+		if s.key == "database.uri" {
+			if uri, err := url.Parse(*value); err == nil {
+				if _, hasPassword := uri.User.Password(); hasPassword {
+					uri.User = url.UserPassword(uri.User.Username(), "REDACTED")
+				}
+				*value = uri.String()
+				continue
+			}
+		}
+		*value = "REDACTED"
+	}
+	return config
+}
+
+// Print writes the configuration as YAML, with secrets redacted
+func (config *Config) Print() error {
+	contents, err := yaml.Marshal(config.Redacted())
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(contents)
+	return err
+}
@@ -0,0 +1,106 @@
+package csrf
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// CookieName is the cookie each browser's token is kept in
+	CookieName = "vote_csrf"
+	// FormField carries the token back in forms
+	FormField = "csrf_token"
+	// Header carries the token back in API requests
+	Header = "X-CSRF-Token"
+)
+
+// Where the request's token, and whether its cookie is limited to HTTPS, are
+// kept in the gin context
+const (
+	tokenKey  = "csrf_token"
+	secureKey = "csrf_secure"
+)
+
+var validToken = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Middleware rejects requests that could change anything unless they send back
+// the token in the browser's cookie: forms in FormField, and API requests,
+// under /api/, in Header. Another site can make a browser send the cookie, but
+// it can't read it to send it back. API requests with a bearer token are left
+// to the token's own check. secure limits the cookie to HTTPS
+func Middleware(secure bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(secureKey, secure)
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+
+		// API requests may not be forms, so their bodies are left alone. Those
+		// carrying an API token don't rely on cookies, which are all another
+		// site can make a browser send, so they need no CSRF token
+		var sent, message string
+		if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			if _, ok := BearerToken(c); ok {
+				return
+			}
+			sent, message = c.GetHeader(Header), "Send the token from /api/csrf in the "+Header+" header"
+		} else {
+			sent, message = c.PostForm(FormField), "This form has expired; go back, reload the page and try again"
+		}
+		token, err := c.Cookie(CookieName)
+		if err != nil || !validToken.MatchString(token) || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			logging.FromContext(c).WithFields(logrus.Fields{"module": "csrf", "method": "Middleware"}).Warn("rejected request without a valid csrf token")
+			c.AbortWithStatusJSON(403, gin.H{"error": message})
+			return
+		}
+	}
+}
+
+// BearerToken returns the API token sent with a request to an /api/ route, if
+// there is one. Other routes always need a session, and an empty token is the
+// same as none, so the request is checked like any other
+func BearerToken(c *gin.Context) (string, bool) {
+	if !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+		return "", false
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == c.GetHeader("Authorization") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// Token returns the browser's token, to put in a form or give to an API client,
+// issuing one in a cookie if the browser doesn't have one yet
+func Token(c *gin.Context) string {
+	if token := c.GetString(tokenKey); token != "" {
+		return token
+	}
+
+	token, err := c.Cookie(CookieName)
+	if err != nil || !validToken.MatchString(token) {
+		token = newToken()
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(CookieName, token, 0, "/", "", c.GetBool(secureKey), true)
+	}
+	c.Set(tokenKey, token)
+	return token
+}
+
+func newToken() string {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(token)
+}
@@ -0,0 +1,145 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const cookieToken = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// serve sends a request through the middleware to a handler that always succeeds
+func serve(req *http.Request) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(false))
+	r.Any("/*path", func(c *gin.Context) {
+		c.String(200, Token(c))
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// request builds a request, with the form fields as its body and the given headers
+func request(method, path string, form url.Values, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return req
+}
+
+func TestMiddleware(t *testing.T) {
+	cookie := CookieName + "=" + cookieToken
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		allowed bool
+	}{
+		{
+			name:    "GET needs no token",
+			req:     request("GET", "/poll/1", nil, nil),
+			allowed: true,
+		},
+		{
+			name:    "form with the cookie's token",
+			req:     request("POST", "/poll/1", url.Values{FormField: {cookieToken}}, map[string]string{"Cookie": cookie}),
+			allowed: true,
+		},
+		{
+			name: "form without a token",
+			req:  request("POST", "/poll/1", url.Values{}, map[string]string{"Cookie": cookie}),
+		},
+		{
+			name: "form with a different token",
+			req:  request("POST", "/poll/1", url.Values{FormField: {strings.Repeat("f", 64)}}, map[string]string{"Cookie": cookie}),
+		},
+		{
+			name: "form token without a cookie",
+			req:  request("POST", "/poll/1", url.Values{FormField: {cookieToken}}, nil),
+		},
+		{
+			name:    "API request with the cookie's token in the header",
+			req:     request("POST", "/api/agenda", nil, map[string]string{"Cookie": cookie, Header: cookieToken}),
+			allowed: true,
+		},
+		{
+			name: "API request without the header",
+			req:  request("POST", "/api/agenda", nil, map[string]string{"Cookie": cookie}),
+		},
+		{
+			name:    "API request with a bearer token",
+			req:     request("POST", "/api/agenda", nil, map[string]string{"Authorization": "Bearer secret"}),
+			allowed: true,
+		},
+		{
+			name: "API request with an empty bearer token and the cookie",
+			req:  request("POST", "/api/agenda", nil, map[string]string{"Cookie": cookie, "Authorization": "Bearer "}),
+		},
+		{
+			name: "bearer token outside the API",
+			req:  request("POST", "/poll/1", url.Values{}, map[string]string{"Cookie": cookie, "Authorization": "Bearer secret"}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serve(test.req)
+			if allowed := w.Code == 200; allowed != test.allowed {
+				t.Errorf("allowed = %v (status %d), want %v", allowed, w.Code, test.allowed)
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		path          string
+		authorization string
+		want          string
+		ok            bool
+	}{
+		{"/api/agenda", "Bearer secret", "secret", true},
+		{"/api/agenda", "Bearer ", "", false},
+		{"/api/agenda", "Basic secret", "", false},
+		{"/api/agenda", "", "", false},
+		{"/poll/1", "Bearer secret", "", false},
+	}
+
+	for _, test := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = request("GET", test.path, nil, map[string]string{"Authorization": test.authorization})
+		if got, ok := BearerToken(c); got != test.want || ok != test.ok {
+			t.Errorf("BearerToken(%s, %q) = %q, %v, want %q, %v", test.path, test.authorization, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	w := serve(request("GET", "/", nil, nil))
+	issued := w.Body.String()
+	if !validToken.MatchString(issued) {
+		t.Fatalf("Token() = %q, want 64 hex characters", issued)
+	}
+	if cookie := w.Header().Get("Set-Cookie"); !strings.HasPrefix(cookie, CookieName+"="+issued) {
+		t.Errorf("Set-Cookie = %q, want the issued token", cookie)
+	}
+
+	w = serve(request("GET", "/", nil, map[string]string{"Cookie": CookieName + "=" + cookieToken}))
+	if got := w.Body.String(); got != cookieToken {
+		t.Errorf("Token() = %q, want the cookie's token %q", got, cookieToken)
+	}
+	if cookie := w.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("Set-Cookie = %q, want none for a browser that already has a token", cookie)
+	}
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIToken lets a script call the API as the user who created it, with the
+// groups they had at the time, without a browser session. Only a hash of the
+// token itself is kept
+type APIToken struct {
+	Id        string    `bson:"_id,omitempty"`
+	Hash      string    `bson:"hash"`
+	Name      string    `bson:"name"`
+	Username  string    `bson:"username"`
+	FullName  string    `bson:"fullName"`
+	Groups    []string  `bson:"groups"`
+	CreatedAt time.Time `bson:"createdAt"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// ensureAPITokenIndexes makes tokens quick to look up, and has MongoDB delete them once they expire
+func ensureAPITokenIndexes(ctx context.Context) error {
+	_, err := Collection("apiTokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func CreateAPIToken(ctx context.Context, token *APIToken) (string, error) {
+	ctx, cancel := begin(ctx, "CreateAPIToken")
+	defer cancel()
+
+	result, err := Collection("apiTokens").InsertOne(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// GetAPITokenByHash returns the unexpired token with the given hash
+func GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	ctx, cancel := begin(ctx, "GetAPITokenByHash")
+	defer cancel()
+
+	var token APIToken
+	err := Collection("apiTokens").FindOne(ctx, map[string]interface{}{
+		"hash":      hash,
+		"expiresAt": map[string]interface{}{"$gt": time.Now()},
+	}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// GetAPITokens returns a user's unexpired tokens, newest first
+func GetAPITokens(ctx context.Context, username string) ([]*APIToken, error) {
+	ctx, cancel := begin(ctx, "GetAPITokens")
+	defer cancel()
+
+	cursor, err := Collection("apiTokens").Find(ctx, map[string]interface{}{
+		"username":  username,
+		"expiresAt": map[string]interface{}{"$gt": time.Now()},
+	}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []*APIToken
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// DeleteAPIToken revokes one of a user's tokens
+func DeleteAPIToken(ctx context.Context, id, username string) error {
+	ctx, cancel := begin(ctx, "DeleteAPIToken")
+	defer cancel()
+
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = Collection("apiTokens").DeleteOne(ctx, map[string]interface{}{"_id": objId, "username": username})
+	return err
+}
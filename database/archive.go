@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ArchiveQuery describes a search of closed polls on behalf of a user
+type ArchiveQuery struct {
+	// Username and Groups decide which hidden polls the user may see
+	Username string
+	Groups   []string
+
+	Text     string
+	Creator  string
+	VoteType string
+	Outcome  string
+	// Polls created at or after From, and before To, when they are set
+	From time.Time
+	To   time.Time
+
+	// Cursor is the id of the last poll on the previous page
+	Cursor string
+	Limit  int
+}
+
+// EnsureIndexes creates the indexes the application's queries rely on
+func EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := begin(ctx, "EnsureIndexes")
+	defer cancel()
+
+	_, err := Collection("polls").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "shortDescription", Value: "text"}, {Key: "longDescription", Value: "text"}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return ensureAPITokenIndexes(ctx)
+}
+
+// SearchClosedPolls returns a page of closed polls matching the query, newest first,
+// along with the cursor for the next page if there is one
+func SearchClosedPolls(ctx context.Context, query ArchiveQuery) ([]*Poll, string, error) {
+	ctx, cancel := begin(ctx, "SearchClosedPolls")
+	defer cancel()
+
+	groups := query.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+
+	filter := bson.A{
+		bson.M{"open": false},
+		bson.M{"pending": bson.M{"$ne": true}},
+		bson.M{"archived": bson.M{"$ne": true}},
+		// Hidden results are only listed for the people who can see them
+		bson.M{"$or": bson.A{
+			bson.M{"hidden": bson.M{"$ne": true}},
+			bson.M{"createdBy": query.Username},
+			bson.M{"managers": query.Username},
+			bson.M{"managerGroups": bson.M{"$in": groups}},
+		}},
+	}
+	if query.Text != "" {
+		filter = append(filter, bson.M{"$text": bson.M{"$search": query.Text}})
+	}
+	if query.Creator != "" {
+		filter = append(filter, bson.M{"createdBy": query.Creator})
+	}
+	if query.VoteType != "" {
+		filter = append(filter, bson.M{"voteType": query.VoteType})
+	}
+	if query.Outcome != "" {
+		filter = append(filter, bson.M{"outcome": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(query.Outcome) + "$", Options: "i"}})
+	}
+	// Poll ids begin with their creation time, so date ranges and the cursor are both ranges of ids
+	if !query.From.IsZero() {
+		filter = append(filter, bson.M{"_id": bson.M{"$gte": primitive.NewObjectIDFromTimestamp(query.From)}})
+	}
+	if !query.To.IsZero() {
+		filter = append(filter, bson.M{"_id": bson.M{"$lt": primitive.NewObjectIDFromTimestamp(query.To)}})
+	}
+	if query.Cursor != "" {
+		cursorId, err := primitive.ObjectIDFromHex(query.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		filter = append(filter, bson.M{"_id": bson.M{"$lt": cursorId}})
+	}
+
+	// Fetch one extra poll to find out if there is another page
+	cursor, err := Collection("polls").Find(ctx, bson.M{"$and": filter},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(query.Limit+1)))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var polls []*Poll
+	if err := cursor.All(ctx, &polls); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(polls) > query.Limit {
+		polls = polls[:query.Limit]
+		next = polls[len(polls)-1].Id
+	}
+	return polls, next, nil
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Attendance records that a member was present at a meeting
+type Attendance struct {
+	Id          string    `bson:"_id,omitempty"`
+	MeetingId   string    `bson:"meetingId"`
+	UserId      string    `bson:"userId"`
+	CheckedInAt time.Time `bson:"checkedInAt"`
+	// MarkedBy is the user who recorded the attendance, which is the member
+	// themselves unless the chair marked them present
+	MarkedBy string `bson:"markedBy"`
+}
+
+// CheckIn marks a member present at a meeting. Checking in again keeps the
+// original record
+func CheckIn(ctx context.Context, meetingId, userId, markedBy string) error {
+	ctx, cancel := begin(ctx, "CheckIn")
+	defer cancel()
+
+	_, err := Collection("attendance").UpdateOne(ctx,
+		map[string]interface{}{"meetingId": meetingId, "userId": userId},
+		map[string]interface{}{"$setOnInsert": map[string]interface{}{
+			"meetingId":   meetingId,
+			"userId":      userId,
+			"checkedInAt": time.Now(),
+			"markedBy":    markedBy,
+		}},
+		options.Update().SetUpsert(true),
+	)
+
+	return err
+}
+
+// CheckOut removes a member's attendance from a meeting
+func CheckOut(ctx context.Context, meetingId, userId string) error {
+	ctx, cancel := begin(ctx, "CheckOut")
+	defer cancel()
+
+	_, err := Collection("attendance").DeleteOne(ctx, map[string]interface{}{"meetingId": meetingId, "userId": userId})
+
+	return err
+}
+
+func IsPresent(ctx context.Context, meetingId, userId string) (bool, error) {
+	ctx, cancel := begin(ctx, "IsPresent")
+	defer cancel()
+
+	count, err := Collection("attendance").CountDocuments(ctx, map[string]interface{}{"meetingId": meetingId, "userId": userId})
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func CountAttendance(ctx context.Context, meetingId string) (int64, error) {
+	ctx, cancel := begin(ctx, "CountAttendance")
+	defer cancel()
+
+	return Collection("attendance").CountDocuments(ctx, map[string]interface{}{"meetingId": meetingId})
+}
+
+// CountPresentVotes counts the ballots in a poll cast by members present at a
+// meeting. Ballots from anyone who wasn't checked in don't count toward quorum
+func CountPresentVotes(ctx context.Context, pollId, meetingId string) (int64, error) {
+	ctx, cancel := begin(ctx, "CountPresentVotes")
+	defer cancel()
+
+	pId, err := primitive.ObjectIDFromHex(pollId)
+	if err != nil {
+		return 0, err
+	}
+
+	present, err := Collection("attendance").Distinct(ctx, "userId", map[string]interface{}{"meetingId": meetingId})
+	if err != nil {
+		return 0, err
+	}
+
+	return Collection("votes").CountDocuments(ctx, map[string]interface{}{
+		"pollId": pId,
+		"userId": map[string]interface{}{"$in": present},
+	})
+}
+
+// GetAttendance returns everyone present at a meeting, in the order they checked in
+func GetAttendance(ctx context.Context, meetingId string) ([]Attendance, error) {
+	ctx, cancel := begin(ctx, "GetAttendance")
+	defer cancel()
+
+	cursor, err := Collection("attendance").Find(ctx, map[string]interface{}{"meetingId": meetingId}, options.Find().SetSort(map[string]interface{}{"checkedInAt": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	attendance := []Attendance{}
+	if err := cursor.All(ctx, &attendance); err != nil {
+		return nil, err
+	}
+
+	return attendance, nil
+}
@@ -2,14 +2,21 @@ package database
 
 import (
 	"context"
-	"os"
+	"sync/atomic"
 	"time"
 
+	"github.com/computersciencehouse/vote/config"
 	"github.com/computersciencehouse/vote/logging"
+	"github.com/computersciencehouse/vote/metrics"
+	"github.com/computersciencehouse/vote/tracing"
 	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type UpsertResult int
@@ -19,34 +26,144 @@ const (
 	Updated UpsertResult = 1
 )
 
-var Client = Connect()
+var (
+	Client *mongo.Client
+	// name is the database everything is kept in
+	name = "vote"
+	// timeout limits every database operation
+	timeout = 10 * time.Second
+	// available is 1 while the database answers pings
+	available int32
+)
 
-func Connect() *mongo.Client {
-	logging.Logger.WithFields(logrus.Fields{"module": "database", "method": "Connect"}).Info("beginning database connection")
+const (
+	// While the database is reachable, it's pinged this often
+	pingInterval = 10 * time.Second
+	// While it isn't, pings back off from retryMin up to retryMax
+	retryMin = time.Second
+	retryMax = 30 * time.Second
+)
 
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
-	defer cancel()
+// Connect sets up the client used by every other function in this package. The
+// driver connects in the background, so this only fails if the configuration
+// is unusable; use Monitor to find out when the database is reachable
+func Connect(config config.Database) error {
+	name = config.Name
+	timeout = config.Timeout
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("VOTE_MONGODB_URI")))
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(config.URI).SetMonitor(commandMonitor))
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "database", "method": "Connect"}).Fatal("error connecting to database")
+		return err
+	}
+
+	Client = client
+	return nil
+}
+
+// Ping checks that the database's primary is reachable
+func Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return Client.Ping(ctx, readpref.Primary())
+}
+
+// Available reports whether the database answered the last ping
+func Available() bool {
+	return atomic.LoadInt32(&available) == 1
+}
+
+// Monitor pings the database until ctx is done, backing off while it's
+// unreachable. Each time it becomes reachable, the indexes are made sure of
+func Monitor(ctx context.Context) {
+	delay := retryMin
+	for {
+		wait := pingInterval
+		if err := Ping(ctx); err == nil {
+			delay = retryMin
+			if atomic.SwapInt32(&available, 1) == 0 {
+				logging.Logger.WithFields(logrus.Fields{"module": "database", "method": "Monitor"}).Info("connected to mongodb")
+				if err := EnsureIndexes(ctx); err != nil {
+					logging.Logger.WithFields(logrus.Fields{"error": err, "module": "database", "method": "Monitor"}).Error("error creating database indexes")
+				}
+			}
+		} else if ctx.Err() == nil {
+			wait = delay
+			delay *= 2
+			if delay > retryMax {
+				delay = retryMax
+			}
+			fields := logrus.Fields{"error": err, "module": "database", "method": "Monitor", "retry_in": wait.String()}
+			if atomic.SwapInt32(&available, 0) == 1 {
+				logging.Logger.WithFields(fields).Error("lost connection to mongodb")
+			} else {
+				logging.Logger.WithFields(fields).Warn("mongodb unreachable")
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
 	}
+}
 
-	if err := client.Ping(ctx, readpref.Primary()); err != nil {
-		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "database", "method": "Connect"}).Fatal("error pinging database")
+// begin starts an operation on the database, in a span named after it and
+// limited by the timeout. The returned function ends both
+func begin(ctx context.Context, operation string) (context.Context, context.CancelFunc) {
+	ctx, span := tracing.Tracer.Start(ctx, "database."+operation)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		span.End()
 	}
+}
 
-	logging.Logger.WithFields(logrus.Fields{"module": "database", "method": "Connect"}).Info("connected to mongodb")
+// commandMonitor logs every database command with the fields of the context
+// it was run with, which ties it to the request that ran it, measures it, and
+// adds it to the operation's span
+var commandMonitor = &event.CommandMonitor{
+	Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+		metrics.CommandSucceeded(ctx, e)
+		trace.SpanFromContext(ctx).AddEvent(e.CommandName, trace.WithAttributes(
+			attribute.Int64("db.duration_ms", e.DurationNanos/int64(time.Millisecond)),
+		))
+		logging.FromContext(ctx).WithFields(logrus.Fields{
+			"module":      "database",
+			"command":     e.CommandName,
+			"duration_ms": e.DurationNanos / int64(time.Millisecond),
+		}).Debug("database command")
+	},
+	Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+		metrics.CommandFailed(ctx, e)
+		span := trace.SpanFromContext(ctx)
+		span.AddEvent(e.CommandName, trace.WithAttributes(
+			attribute.Int64("db.duration_ms", e.DurationNanos/int64(time.Millisecond)),
+			attribute.String("error", e.Failure),
+		))
+		span.SetStatus(codes.Error, e.Failure)
+		logging.FromContext(ctx).WithFields(logrus.Fields{
+			"module":      "database",
+			"command":     e.CommandName,
+			"duration_ms": e.DurationNanos / int64(time.Millisecond),
+			"error":       e.Failure,
+		}).Warn("database command failed")
+	},
+}
 
-	return client
+// Collection returns one of the app's collections
+func Collection(collection string) *mongo.Collection {
+	return Client.Database(name).Collection(collection)
 }
 
 func Disconnect() {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
 	defer cancel()
 
 	if err := Client.Disconnect(ctx); err != nil {
-		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "database", "method": "Disconnect"}).Fatal("error disconnecting from database")
+		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "database", "method": "Disconnect"}).Error("error disconnecting from database")
+		return
 	}
 
 	logging.Logger.WithFields(logrus.Fields{"module": "database", "method": "Disconnect"}).Info("disconnected from database")
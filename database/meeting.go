@@ -0,0 +1,147 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Meeting struct {
+	Id        string    `bson:"_id,omitempty"`
+	Title     string    `bson:"title"`
+	Date      time.Time `bson:"date"`
+	Chair     string    `bson:"chair"`
+	CreatedBy string    `bson:"createdBy"`
+	// Agenda holds the ids of the meeting's polls, in the order they'll be voted on
+	Agenda []string `bson:"agenda"`
+}
+
+// CanChair reports whether a user may run the meeting's agenda
+func (meeting *Meeting) CanChair(username string) bool {
+	return meeting.Chair == username || meeting.CreatedBy == username
+}
+
+func CreateMeeting(ctx context.Context, meeting *Meeting) (string, error) {
+	ctx, cancel := begin(ctx, "CreateMeeting")
+	defer cancel()
+
+	if meeting.Agenda == nil {
+		meeting.Agenda = []string{}
+	}
+
+	result, err := Collection("meetings").InsertOne(ctx, meeting)
+	if err != nil {
+		return "", err
+	}
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+func GetMeeting(ctx context.Context, id string) (*Meeting, error) {
+	ctx, cancel := begin(ctx, "GetMeeting")
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(id)
+	var meeting Meeting
+	if err := Collection("meetings").FindOne(ctx, map[string]interface{}{"_id": objId}).Decode(&meeting); err != nil {
+		return nil, err
+	}
+
+	return &meeting, nil
+}
+
+// GetMeetings returns every meeting, most recent first
+func GetMeetings(ctx context.Context) ([]*Meeting, error) {
+	ctx, cancel := begin(ctx, "GetMeetings")
+	defer cancel()
+
+	cursor, err := Collection("meetings").Find(ctx, map[string]interface{}{}, options.Find().SetSort(bson.D{{Key: "date", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var meetings []*Meeting
+	cursor.All(ctx, &meetings)
+
+	return meetings, nil
+}
+
+// AddPoll puts a poll at the end of the meeting's agenda
+func (meeting *Meeting) AddPoll(ctx context.Context, pollId string) error {
+	ctx, cancel := begin(ctx, "Meeting.AddPoll")
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(meeting.Id)
+	pId, err := primitive.ObjectIDFromHex(pollId)
+	if err != nil {
+		return err
+	}
+
+	_, err = Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": pId}, map[string]interface{}{"$set": map[string]interface{}{"meetingId": meeting.Id}})
+	if err != nil {
+		return err
+	}
+
+	_, err = Collection("meetings").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{"$push": map[string]interface{}{"agenda": pollId}})
+	if err != nil {
+		return err
+	}
+
+	meeting.Agenda = append(meeting.Agenda, pollId)
+	return nil
+}
+
+// SetAgenda saves a new order for the meeting's polls
+func (meeting *Meeting) SetAgenda(ctx context.Context, agenda []string) error {
+	ctx, cancel := begin(ctx, "Meeting.SetAgenda")
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(meeting.Id)
+
+	_, err := Collection("meetings").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{"$set": map[string]interface{}{"agenda": agenda}})
+	if err != nil {
+		return err
+	}
+
+	meeting.Agenda = agenda
+	return nil
+}
+
+// GetPolls returns the meeting's polls in agenda order
+func (meeting *Meeting) GetPolls(ctx context.Context) ([]*Poll, error) {
+	ctx, cancel := begin(ctx, "Meeting.GetPolls")
+	defer cancel()
+
+	ids := make([]primitive.ObjectID, 0, len(meeting.Agenda))
+	for _, id := range meeting.Agenda {
+		objId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, objId)
+	}
+
+	cursor, err := Collection("polls").Find(ctx, map[string]interface{}{"_id": map[string]interface{}{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*Poll
+	cursor.All(ctx, &found)
+
+	byId := make(map[string]*Poll, len(found))
+	for _, poll := range found {
+		byId[poll.Id] = poll
+	}
+	polls := make([]*Poll, 0, len(found))
+	for _, id := range meeting.Agenda {
+		if poll, ok := byId[id]; ok {
+			polls = append(polls, poll)
+		}
+	}
+
+	return polls, nil
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MultiVote is a whole multi-question ballot. It's stored as a single vote so
+// the ballot is cast all at once, and counts once towards turnout
+type MultiVote struct {
+	Id      string             `bson:"_id,omitempty"`
+	PollId  primitive.ObjectID `bson:"pollId"`
+	UserId  string             `bson:"userId"`
+	Answers map[string]Answer  `bson:"answers"`
+}
+
+// Answer is the vote for a single question, keyed by the question's id
+type Answer struct {
+	Option  string         `bson:"option,omitempty"`
+	Options map[string]int `bson:"options,omitempty"`
+}
+
+func CastMultiVote(ctx context.Context, vote *MultiVote) error {
+	ctx, cancel := begin(ctx, "CastMultiVote")
+	defer cancel()
+
+	_, err := Collection("votes").InsertOne(ctx, vote)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetQuestionResults tallies every question on a multi-question ballot,
+// returning each question's results by its id
+func (poll *Poll) GetQuestionResults(ctx context.Context) (map[string]map[string]int, error) {
+	ctx, cancel := begin(ctx, "Poll.GetQuestionResults")
+	defer cancel()
+
+	pollId, _ := primitive.ObjectIDFromHex(poll.Id)
+
+	cursor, err := Collection("votes").Find(ctx, map[string]interface{}{"pollId": pollId})
+	if err != nil {
+		return nil, err
+	}
+
+	var votes []MultiVote
+	if err := cursor.All(ctx, &votes); err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]map[string]int)
+	for _, question := range poll.Questions {
+		if question.VoteType == POLL_TYPE_RANKED {
+			rankings := make([]map[string]int, 0, len(votes))
+			for _, vote := range votes {
+				if answer, ok := vote.Answers[question.Id]; ok && len(answer.Options) > 0 {
+					rankings = append(rankings, answer.Options)
+				}
+			}
+			results[question.Id] = tallyRanked(rankings)
+		} else {
+			choices := make([]string, 0, len(votes))
+			for _, vote := range votes {
+				if answer, ok := vote.Answers[question.Id]; ok && answer.Option != "" {
+					choices = append(choices, answer.Option)
+				}
+			}
+			results[question.Id] = tallySimple(question.Options, choices)
+		}
+	}
+
+	return results, nil
+}
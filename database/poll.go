@@ -2,89 +2,401 @@ package database
 
 import (
 	"context"
-	"sort"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
 	"time"
 
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/computersciencehouse/vote/metrics"
+	"github.com/computersciencehouse/vote/tracing"
+	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Poll struct {
-	Id               string   `bson:"_id,omitempty"`
-	CreatedBy        string   `bson:"createdBy"`
+	Id                 string              `bson:"_id,omitempty"`
+	CreatedBy          string              `bson:"createdBy"`
+	ShortDescription   string              `bson:"shortDescription"`
+	LongDescription    string              `bson:"longDescription"`
+	VoteType           string              `bson:"voteType"`
+	Options            []string            `bson:"options"`
+	OptionDescriptions []OptionDescription `bson:"optionDescriptions,omitempty"`
+	Open               bool                `bson:"open"`
+	// Pending polls haven't been opened yet
+	Pending   bool       `bson:"pending,omitempty"`
+	OpensAt   *time.Time `bson:"opensAt,omitempty"`
+	ClosesAt  *time.Time `bson:"closesAt,omitempty"`
+	Hidden    bool       `bson:"hidden"`
+	Archived  bool       `bson:"archived,omitempty"`
+	Official  bool       `bson:"official,omitempty"`
+	MeetingId string     `bson:"meetingId,omitempty"`
+	// RequireAttendance only lets members checked in to the poll's meeting vote
+	RequireAttendance bool `bson:"requireAttendance,omitempty"`
+	// Quorum is the fraction of members present at the meeting who must vote
+	Quorum        float64        `bson:"quorum,omitempty"`
+	Outcome       string         `bson:"outcome"`
+	AllowWriteIns bool           `bson:"writeins"`
+	OpenBallots   bool           `bson:"openBallots,omitempty"`
+	Managers      []string       `bson:"managers,omitempty"`
+	ManagerGroups []string       `bson:"managerGroups,omitempty"`
+	History       []HistoryEntry `bson:"history,omitempty"`
+	// Voted is set before the first ballot is stored, so an edit can't change
+	// the ballot out from under it
+	Voted bool `bson:"voted,omitempty"`
+	// Questions are only used by multi-question ballots
+	Questions []Question `bson:"questions,omitempty"`
+}
+
+// Question is one question on a multi-question ballot
+type Question struct {
+	Id               string   `bson:"id"`
 	ShortDescription string   `bson:"shortDescription"`
 	LongDescription  string   `bson:"longDescription"`
 	VoteType         string   `bson:"voteType"`
 	Options          []string `bson:"options"`
-	Open             bool     `bson:"open"`
-	Hidden           bool     `bson:"hidden"`
 	AllowWriteIns    bool     `bson:"writeins"`
+	Outcome          string   `bson:"outcome,omitempty"`
+}
+
+// HistoryEntry records a single change made to a poll after it was created
+type HistoryEntry struct {
+	Time    time.Time `bson:"time"`
+	User    string    `bson:"user"`
+	Action  string    `bson:"action"`
+	Details string    `bson:"details,omitempty"`
+	Reason  string    `bson:"reason,omitempty"`
+}
+
+type OptionDescription struct {
+	Option      string `bson:"option"`
+	Description string `bson:"description"`
 }
 
 const POLL_TYPE_SIMPLE = "simple"
 const POLL_TYPE_RANKED = "ranked"
 
-func GetPoll(id string) (*Poll, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+// POLL_TYPE_MULTI polls are ballots made up of several questions, each with
+// its own vote type and options, that are voted on together
+const POLL_TYPE_MULTI = "multi"
+
+// OUTCOME_TIE is recorded as the outcome of a poll whose leading options are tied
+const OUTCOME_TIE = "Tie"
+
+// DescriptionFor returns the description given to an option, if any
+func (poll *Poll) DescriptionFor(option string) string {
+	for _, d := range poll.OptionDescriptions {
+		if d.Option == option {
+			return d.Description
+		}
+	}
+	return ""
+}
+
+// CanManage reports whether a user may close, hide, reveal or edit the poll,
+// either as its creator or through its list of managers
+func (poll *Poll) CanManage(username string, groups []string) bool {
+	if poll.CreatedBy == username {
+		return true
+	}
+	for _, manager := range poll.Managers {
+		if manager == username {
+			return true
+		}
+	}
+	for _, managerGroup := range poll.ManagerGroups {
+		for _, group := range groups {
+			if managerGroup == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func GetPoll(ctx context.Context, id string) (*Poll, error) {
+	ctx, cancel := begin(ctx, "GetPoll")
 	defer cancel()
 
 	objId, _ := primitive.ObjectIDFromHex(id)
 	var poll Poll
-	if err := Client.Database("vote").Collection("polls").FindOne(ctx, map[string]interface{}{"_id": objId}).Decode(&poll); err != nil {
+	if err := Collection("polls").FindOne(ctx, map[string]interface{}{"_id": objId}).Decode(&poll); err != nil {
 		return nil, err
 	}
 
 	return &poll, nil
 }
 
-func (poll *Poll) Close() error {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+// CreatedAt returns the time the poll was created, taken from its id
+func (poll *Poll) CreatedAt() time.Time {
+	objId, _ := primitive.ObjectIDFromHex(poll.Id)
+	return objId.Timestamp()
+}
+
+// QuorumNeeded is how many votes the poll needs to meet quorum, given how many
+// members are present at its meeting
+func (poll *Poll) QuorumNeeded(present int64) int64 {
+	return int64(math.Ceil(poll.Quorum * float64(present)))
+}
+
+// Close stops the poll accepting votes and records its outcome. Multi-question
+// ballots record an outcome for each question instead
+func (poll *Poll) Close(ctx context.Context) error {
+	fields := poll.closingFields(ctx)
+
+	ctx, cancel := begin(ctx, "Poll.Close")
 	defer cancel()
 
 	objId, _ := primitive.ObjectIDFromHex(poll.Id)
 
-	_, err := Client.Database("vote").Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{"$set": map[string]interface{}{"open": false}})
+	_, err := Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{"$set": fields})
 	if err != nil {
 		return err
 	}
 
+	poll.markClosed(fields)
 	return nil
 }
 
-func (poll *Poll) Hide() error {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+// computeOutcome finds the option with the most votes, or OUTCOME_TIE if the lead is shared
+func (poll *Poll) computeOutcome(ctx context.Context) (string, error) {
+	results, err := poll.GetResult(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return outcomeOf(results), nil
+}
+
+// closingFields tallies the poll and returns the fields to set when it closes.
+// A poll whose tally fails still closes, and its outcome is recorded later
+func (poll *Poll) closingFields(ctx context.Context) map[string]interface{} {
+	fields := map[string]interface{}{"open": false}
+	logger := logging.FromContext(ctx).WithFields(logrus.Fields{"module": "database", "method": "Poll.closingFields", "poll": poll.Id})
+
+	if poll.VoteType != POLL_TYPE_MULTI {
+		outcome, err := poll.computeOutcome(ctx)
+		if err != nil {
+			logger.WithField("error", err).Error("error tallying closed poll")
+			fields["outcome"] = nil
+			return fields
+		}
+		fields["outcome"] = outcome
+		return fields
+	}
+
+	results, err := poll.GetQuestionResults(ctx)
+	if err != nil {
+		logger.WithField("error", err).Error("error tallying closed poll")
+		return fields
+	}
+
+	questions := append([]Question{}, poll.Questions...)
+	for i := range questions {
+		questions[i].Outcome = outcomeOf(results[questions[i].Id])
+	}
+	fields["questions"] = questions
+	return fields
+}
+
+// markClosed brings the poll in line with closing fields once they're saved
+func (poll *Poll) markClosed(fields map[string]interface{}) {
+	poll.Open = false
+	if outcome, ok := fields["outcome"].(string); ok {
+		poll.Outcome = outcome
+	}
+	if questions, ok := fields["questions"].([]Question); ok {
+		poll.Questions = questions
+	}
+}
+
+func (poll *Poll) Hide(ctx context.Context) error {
+	ctx, cancel := begin(ctx, "Poll.Hide")
 	defer cancel()
 
 	objId, _ := primitive.ObjectIDFromHex(poll.Id)
 
-	_, err := Client.Database("vote").Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{"$set": map[string]interface{}{"hidden": true}})
+	_, err := Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{"$set": map[string]interface{}{"hidden": true}})
+	if err != nil {
+		return err
+	}
+
+	poll.Hidden = true
+	return nil
+}
+
+func (poll *Poll) Reveal(ctx context.Context) error {
+	ctx, cancel := begin(ctx, "Poll.Reveal")
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(poll.Id)
+
+	_, err := Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{"$set": map[string]interface{}{"hidden": false}})
+	if err != nil {
+		return err
+	}
+
+	poll.Hidden = false
+	return nil
+}
+
+// Start opens a pending poll for voting
+func (poll *Poll) Start(ctx context.Context) error {
+	ctx, cancel := begin(ctx, "Poll.Start")
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(poll.Id)
+
+	_, err := Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{
+		"$set":   map[string]interface{}{"open": true, "pending": false},
+		"$unset": map[string]interface{}{"opensAt": ""},
+	})
+	if err != nil {
+		return err
+	}
+
+	poll.Open = true
+	poll.Pending = false
+	poll.OpensAt = nil
+	return nil
+}
+
+// Override applies an administrator's action to the poll and records it in the
+// poll's history in the same update, so neither is saved without the other.
+// newOwner is only used to transfer the poll
+func (poll *Poll) Override(ctx context.Context, action, newOwner string, entry HistoryEntry) error {
+	updated := *poll
+	set := map[string]interface{}{}
+	unset := map[string]interface{}{}
+	switch action {
+	case "close":
+	case "reopen":
+		set["open"], set["pending"] = true, false
+		unset["closesAt"] = ""
+		updated.Open, updated.Pending, updated.ClosesAt = true, false, nil
+	case "hide":
+		set["hidden"] = true
+		updated.Hidden = true
+	case "reveal":
+		set["hidden"] = false
+		updated.Hidden = false
+	case "archive":
+		set["archived"] = true
+		updated.Archived = true
+	case "unarchive":
+		set["archived"] = false
+		updated.Archived = false
+	case "transfer":
+		set["createdBy"] = newOwner
+		updated.CreatedBy = newOwner
+	default:
+		return fmt.Errorf("unknown override %q", action)
+	}
+
+	// Archiving an open poll closes it too, and both record its outcome
+	if action == "close" || (action == "archive" && poll.Open) {
+		fields := poll.closingFields(ctx)
+		for field, value := range fields {
+			set[field] = value
+		}
+		updated.markClosed(fields)
+	}
+
+	ctx, cancel := begin(ctx, "Poll.Override")
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(poll.Id)
+
+	update := map[string]interface{}{
+		"$set":  set,
+		"$push": map[string]interface{}{"history": entry},
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	_, err := Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId}, update)
+	if err != nil {
+		return err
+	}
+
+	updated.History = append(updated.History, entry)
+	*poll = updated
+	return nil
+}
+
+// ErrBallotsCast is returned when an edit would change a ballot that has already been cast
+var ErrBallotsCast = errors.New("ballots have been cast in this poll")
+
+// Edit saves the poll's descriptions, options and settings, and records the edit in its history.
+// If the edit changes the ballot, it is only saved while no ballots have been cast
+func (poll *Poll) Edit(ctx context.Context, entry HistoryEntry, changesBallot bool) error {
+	ctx, cancel := begin(ctx, "Poll.Edit")
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(poll.Id)
+
+	filter := map[string]interface{}{"_id": objId}
+	if changesBallot {
+		filter["voted"] = map[string]interface{}{"$ne": true}
+	}
+
+	result, err := Collection("polls").UpdateOne(ctx, filter, map[string]interface{}{
+		"$set": map[string]interface{}{
+			"shortDescription":   poll.ShortDescription,
+			"longDescription":    poll.LongDescription,
+			"voteType":           poll.VoteType,
+			"options":            poll.Options,
+			"optionDescriptions": poll.OptionDescriptions,
+			"writeins":           poll.AllowWriteIns,
+			"openBallots":        poll.OpenBallots,
+			"official":           poll.Official,
+		},
+		"$push": map[string]interface{}{"history": entry},
+	})
 	if err != nil {
 		return err
 	}
+	if result.MatchedCount == 0 {
+		return ErrBallotsCast
+	}
 
+	poll.History = append(poll.History, entry)
 	return nil
 }
 
-func (poll *Poll) Reveal() error {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+// SetManagers replaces the poll's managers and records the change in its history
+func (poll *Poll) SetManagers(ctx context.Context, managers, managerGroups []string, entry HistoryEntry) error {
+	ctx, cancel := begin(ctx, "Poll.SetManagers")
 	defer cancel()
 
 	objId, _ := primitive.ObjectIDFromHex(poll.Id)
 
-	_, err := Client.Database("vote").Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{"$set": map[string]interface{}{"hidden": false}})
+	_, err := Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{
+		"$set": map[string]interface{}{
+			"managers":      managers,
+			"managerGroups": managerGroups,
+		},
+		"$push": map[string]interface{}{"history": entry},
+	})
 	if err != nil {
 		return err
 	}
 
+	poll.Managers = managers
+	poll.ManagerGroups = managerGroups
+	poll.History = append(poll.History, entry)
 	return nil
 }
 
-func CreatePoll(poll *Poll) (string, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+func CreatePoll(ctx context.Context, poll *Poll) (string, error) {
+	ctx, cancel := begin(ctx, "CreatePoll")
 	defer cancel()
 
-	result, err := Client.Database("vote").Collection("polls").InsertOne(ctx, poll)
+	result, err := Collection("polls").InsertOne(ctx, poll)
 	if err != nil {
 		return "", err
 	}
@@ -92,14 +404,71 @@ func CreatePoll(poll *Poll) (string, error) {
 	return result.InsertedID.(primitive.ObjectID).Hex(), nil
 }
 
-func GetOpenPolls() ([]*Poll, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+// CreatePolls creates several polls together. If any of them can't be created,
+// the ones that were are deleted again so that nothing is left half done
+func CreatePolls(ctx context.Context, polls []*Poll) ([]string, error) {
+	ctx, span := tracing.Tracer.Start(ctx, "database.CreatePolls")
+	defer span.End()
+
+	ids := make([]string, 0, len(polls))
+	for _, poll := range polls {
+		id, err := CreatePoll(ctx, poll)
+		if err != nil {
+			for _, created := range ids {
+				DeletePoll(ctx, created)
+			}
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// DeletePoll removes a poll along with every vote cast in it
+func DeletePoll(ctx context.Context, id string) error {
+	ctx, cancel := begin(ctx, "DeletePoll")
 	defer cancel()
 
-	cursor, err := Client.Database("vote").Collection("polls").Find(ctx, map[string]interface{}{"open": true})
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := Collection("votes").DeleteMany(ctx, map[string]interface{}{"pollId": objId}); err != nil {
+		return err
+	}
+	if _, err := Collection("polls").DeleteOne(ctx, map[string]interface{}{"_id": objId}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetAllPolls returns every poll, including archived ones, newest first
+func GetAllPolls(ctx context.Context) ([]*Poll, error) {
+	ctx, cancel := begin(ctx, "GetAllPolls")
+	defer cancel()
+
+	cursor, err := Collection("polls").Find(ctx, map[string]interface{}{}, options.Find().SetSort(map[string]interface{}{"_id": -1}))
 	if err != nil {
 		return nil, err
+	}
 
+	var polls []*Poll
+	cursor.All(ctx, &polls)
+
+	return polls, nil
+}
+
+// GetPendingPolls returns the polls that haven't opened yet, soonest first
+func GetPendingPolls(ctx context.Context) ([]*Poll, error) {
+	ctx, cancel := begin(ctx, "GetPendingPolls")
+	defer cancel()
+
+	cursor, err := Collection("polls").Find(ctx, map[string]interface{}{"pending": true, "archived": map[string]interface{}{"$ne": true}},
+		options.Find().SetSort(bson.D{{Key: "opensAt", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
 	}
 
 	var polls []*Poll
@@ -108,11 +477,12 @@ func GetOpenPolls() ([]*Poll, error) {
 	return polls, nil
 }
 
-func GetClosedOwnedPolls(userId string) ([]*Poll, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+// GetPollsDueToOpen returns pending polls scheduled to open at or before the given time
+func GetPollsDueToOpen(ctx context.Context, now time.Time) ([]*Poll, error) {
+	ctx, cancel := begin(ctx, "GetPollsDueToOpen")
 	defer cancel()
 
-	cursor, err := Client.Database("vote").Collection("polls").Find(ctx, map[string]interface{}{"createdBy": userId, "open": false})
+	cursor, err := Collection("polls").Find(ctx, map[string]interface{}{"pending": true, "opensAt": map[string]interface{}{"$lte": now}})
 	if err != nil {
 		return nil, err
 	}
@@ -123,41 +493,12 @@ func GetClosedOwnedPolls(userId string) ([]*Poll, error) {
 	return polls, nil
 }
 
-func GetClosedVotedPolls(userId string) ([]*Poll, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+// GetPollsDueToClose returns open polls scheduled to close at or before the given time
+func GetPollsDueToClose(ctx context.Context, now time.Time) ([]*Poll, error) {
+	ctx, cancel := begin(ctx, "GetPollsDueToClose")
 	defer cancel()
 
-	cursor, err := Client.Database("vote").Collection("votes").Aggregate(ctx, mongo.Pipeline{
-		{{
-			"$match", bson.D{
-				{"userId", userId},
-			},
-		}},
-		{{
-			"$lookup", bson.D{
-				{"from", "polls"},
-				{"localField", "pollId"},
-				{"foreignField", "_id"},
-				{"as", "polls"},
-			},
-		}},
-		{{
-			"$unwind", bson.D{
-				{"path", "$polls"},
-				{"preserveNullAndEmptyArrays", false},
-			},
-		}},
-		{{
-			"$replaceRoot", bson.D{
-				{"newRoot", "$polls"},
-			},
-		}},
-		{{
-			"$match", bson.D{
-				{"open", false},
-			},
-		}},
-	})
+	cursor, err := Collection("polls").Find(ctx, map[string]interface{}{"open": true, "closesAt": map[string]interface{}{"$lte": now}})
 	if err != nil {
 		return nil, err
 	}
@@ -168,25 +509,128 @@ func GetClosedVotedPolls(userId string) ([]*Poll, error) {
 	return polls, nil
 }
 
-func (poll *Poll) GetResult() (map[string]int, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+// outcomeBatch limits how many outcomes RecordMissingOutcomes tallies at once
+const outcomeBatch = 20
+
+// RecordMissingOutcomes tallies closed polls that have no outcome, because they
+// closed before outcomes were recorded or their tally failed when they closed
+func RecordMissingOutcomes(ctx context.Context) error {
+	findCtx, cancel := begin(ctx, "RecordMissingOutcomes")
+	defer cancel()
+
+	cursor, err := Collection("polls").Find(findCtx, map[string]interface{}{
+		"open":     false,
+		"pending":  map[string]interface{}{"$ne": true},
+		"voteType": map[string]interface{}{"$ne": POLL_TYPE_MULTI},
+		"outcome":  nil,
+	}, options.Find().SetLimit(outcomeBatch))
+	if err != nil {
+		return err
+	}
+
+	var polls []*Poll
+	if err := cursor.All(findCtx, &polls); err != nil {
+		return err
+	}
+
+	// One poll that can't be tallied shouldn't hold up the rest of the batch
+	var failed []string
+	for _, poll := range polls {
+		if err := poll.recordOutcome(ctx); err != nil {
+			logging.FromContext(ctx).WithFields(logrus.Fields{"error": err, "module": "database", "method": "RecordMissingOutcomes", "poll": poll.Id}).Error("error recording poll outcome")
+			failed = append(failed, poll.Id+": "+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("recording %d of %d outcomes failed: %s", len(failed), len(polls), strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// recordOutcome tallies a closed poll and saves its outcome
+func (poll *Poll) recordOutcome(ctx context.Context) error {
+	outcome, err := poll.computeOutcome(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := begin(ctx, "Poll.recordOutcome")
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(poll.Id)
+	_, err = Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{"$set": map[string]interface{}{"outcome": outcome}})
+	return err
+}
+
+func GetOpenPolls(ctx context.Context) ([]*Poll, error) {
+	ctx, cancel := begin(ctx, "GetOpenPolls")
+	defer cancel()
+
+	cursor, err := Collection("polls").Find(ctx, map[string]interface{}{"open": true, "archived": map[string]interface{}{"$ne": true}},
+		// Official polls are pinned above everything else
+		options.Find().SetSort(bson.D{{Key: "official", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		return nil, err
+
+	}
+
+	var polls []*Poll
+	cursor.All(ctx, &polls)
+
+	return polls, nil
+}
+
+// GetClosedPolls returns the closed polls a user either created or voted in, newest first
+func GetClosedPolls(ctx context.Context, userId string) ([]*Poll, error) {
+	ctx, cancel := begin(ctx, "GetClosedPolls")
+	defer cancel()
+
+	votedIds, err := Collection("votes").Distinct(ctx, "pollId", map[string]interface{}{"userId": userId})
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := Collection("polls").Find(ctx, bson.M{
+		"open":     false,
+		"pending":  bson.M{"$ne": true},
+		"archived": bson.M{"$ne": true},
+		"$or": bson.A{
+			bson.M{"createdBy": userId},
+			bson.M{"_id": bson.M{"$in": votedIds}},
+		},
+	}, options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	var polls []*Poll
+	cursor.All(ctx, &polls)
+
+	return polls, nil
+}
+
+func (poll *Poll) GetResult(ctx context.Context) (map[string]int, error) {
+	defer metrics.ObserveResult(poll.VoteType, time.Now())
+
+	ctx, cancel := begin(ctx, "Poll.GetResult")
 	defer cancel()
 
 	pollId, _ := primitive.ObjectIDFromHex(poll.Id)
 	finalResult := make(map[string]int)
 
 	if poll.VoteType == POLL_TYPE_SIMPLE {
-		cursor, err := Client.Database("vote").Collection("votes").Aggregate(ctx, mongo.Pipeline{
+		cursor, err := Collection("votes").Aggregate(ctx, mongo.Pipeline{
 			{{
-				"$match", bson.D{
-					{"pollId", pollId},
+				Key: "$match", Value: bson.D{
+					{Key: "pollId", Value: pollId},
 				},
 			}},
 			{{
-				"$group", bson.D{
-					{"_id", "$option"},
-					{"count", bson.D{
-						{"$sum", 1},
+				Key: "$group", Value: bson.D{
+					{Key: "_id", Value: "$option"},
+					{Key: "count", Value: bson.D{
+						{Key: "$sum", Value: 1},
 					}},
 				},
 			}},
@@ -208,10 +652,10 @@ func (poll *Poll) GetResult() (map[string]int, error) {
 		}
 		return finalResult, nil
 	} else if poll.VoteType == POLL_TYPE_RANKED {
-		cursor, err := Client.Database("vote").Collection("votes").Aggregate(ctx, mongo.Pipeline{
+		cursor, err := Collection("votes").Aggregate(ctx, mongo.Pipeline{
 			{{
-				"$match", bson.D{
-					{"pollId", pollId},
+				Key: "$match", Value: bson.D{
+					{Key: "pollId", Value: pollId},
 				},
 			}},
 		})
@@ -222,67 +666,14 @@ func (poll *Poll) GetResult() (map[string]int, error) {
 		var votes []RankedVote
 		cursor.All(ctx, &votes)
 
-		voteCount := len(votes)
-
-		// ALRIGHT LETS GO INSTANT RUNOFF VOTE LOGIC GOES HERE
-		for {
-			// Create an empty result for counting at this iteration
-			results := make(map[string]int)
-			// Iterate through all cast votes
-			for _, vote := range votes {
-				// Create a list of the options in this vote and sort by preference
-				options := make([]string, 0, len(vote.Options))
-				for key := range vote.Options {
-					options = append(options, key)
-				}
-				sort.SliceStable(options, func(i, j int) bool {
-					return vote.Options[options[i]] < vote.Options[options[j]]
-				})
-
-				// Add a vote for the highest preference option
-				for _, option := range options {
-					// If that option has been eliminated, skip it and go to the next one
-					if containsKey(finalResult, option) {
-						continue
-					}
-					results[option] += 1
-					break
-				}
-			}
-
-			// Once we've gone through all votes, check if we have any options
-			// that have received more than half of the possible votes
-			for _, count := range results {
-				// If so, we're done
-				// This means we won't randomly mess with ties
-				if count*2 >= voteCount {
-					for k, c := range results {
-						finalResult[k] = c
-					}
-					return finalResult, nil
-				}
-			}
-			// If no option has won yet, find the option with the least votes and eliminate
-			// it, noting the number of votes it recieved at the time
-			options := make([]string, 0, len(finalResult))
-			for key := range results {
-				options = append(options, key)
-			}
-			sort.SliceStable(options, func(i, j int) bool {
-				return results[options[i]] < results[options[j]]
-			})
-
-			finalResult[options[len(options)-1]] = results[options[len(options)-1]]
+		rankings := make([]map[string]int, 0, len(votes))
+		for _, vote := range votes {
+			rankings = append(rankings, vote.Options)
 		}
+		return tallyRanked(rankings), nil
+	} else if poll.VoteType == POLL_TYPE_MULTI {
+		// A multi-question ballot has no single set of results, see GetQuestionResults
+		return finalResult, nil
 	}
 	return nil, nil
 }
-
-func containsKey(arr map[string]int, val string) bool {
-	for key, _ := range arr {
-		if key == val {
-			return true
-		}
-	}
-	return false
-}
@@ -2,7 +2,6 @@ package database
 
 import (
 	"context"
-	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -14,11 +13,37 @@ type RankedVote struct {
 	Options map[string]int     `bson:"options"`
 }
 
-func CastRankedVote(vote *RankedVote) error {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+func CastRankedVote(ctx context.Context, vote *RankedVote) error {
+	ctx, cancel := begin(ctx, "CastRankedVote")
 	defer cancel()
 
-	_, err := Client.Database("vote").Collection("votes").InsertOne(ctx, vote)
+	if err := markVoted(ctx, vote.PollId); err != nil {
+		return err
+	}
+
+	_, err := Collection("votes").InsertOne(ctx, vote)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CastRankedVotes inserts many ranked votes at once, such as when importing a poll
+func CastRankedVotes(ctx context.Context, votes []RankedVote) error {
+	ctx, cancel := begin(ctx, "CastRankedVotes")
+	defer cancel()
+
+	if len(votes) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, 0, len(votes))
+	for i := range votes {
+		documents = append(documents, votes[i])
+	}
+
+	_, err := Collection("votes").InsertMany(ctx, documents)
 	if err != nil {
 		return err
 	}
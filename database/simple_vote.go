@@ -2,7 +2,6 @@ package database
 
 import (
 	"context"
-	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -19,11 +18,15 @@ type SimpleResult struct {
 	Count  int    `bson:"count"`
 }
 
-func CastSimpleVote(vote *SimpleVote) error {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+func CastSimpleVote(ctx context.Context, vote *SimpleVote) error {
+	ctx, cancel := begin(ctx, "CastSimpleVote")
 	defer cancel()
 
-	_, err := Client.Database("vote").Collection("votes").InsertOne(ctx, vote)
+	if err := markVoted(ctx, vote.PollId); err != nil {
+		return err
+	}
+
+	_, err := Collection("votes").InsertOne(ctx, vote)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,110 @@
+package database
+
+import "sort"
+
+// tallySimple counts one vote for each choice, starting every option at zero
+// so options nobody picked still show up. Choices that aren't options, such
+// as write-ins, are counted too
+func tallySimple(options []string, choices []string) map[string]int {
+	finalResult := make(map[string]int)
+	for _, opt := range options {
+		finalResult[opt] = 0
+	}
+	for _, choice := range choices {
+		finalResult[choice]++
+	}
+	return finalResult
+}
+
+// tallyRanked runs an instant runoff over a set of rankings. Options eliminated
+// along the way keep the count they had when they were eliminated
+func tallyRanked(rankings []map[string]int) map[string]int {
+	finalResult := make(map[string]int)
+	voteCount := len(rankings)
+
+	// ALRIGHT LETS GO INSTANT RUNOFF VOTE LOGIC GOES HERE
+	for {
+		// Create an empty result for counting at this iteration
+		results := make(map[string]int)
+		// Iterate through all cast votes
+		for _, ranking := range rankings {
+			// Create a list of the options in this vote and sort by preference.
+			// Imported ballots can rank options equally, and those go by name
+			options := make([]string, 0, len(ranking))
+			for key := range ranking {
+				options = append(options, key)
+			}
+			sort.Slice(options, func(i, j int) bool {
+				if ranking[options[i]] != ranking[options[j]] {
+					return ranking[options[i]] < ranking[options[j]]
+				}
+				return options[i] < options[j]
+			})
+
+			// Add a vote for the highest preference option
+			for _, option := range options {
+				// If that option has been eliminated, skip it and go to the next one
+				if containsKey(finalResult, option) {
+					continue
+				}
+				results[option] += 1
+				break
+			}
+		}
+
+		// Every remaining ballot has run out of preferences, so there is nothing left to count
+		if len(results) == 0 {
+			return finalResult
+		}
+
+		// Once we've gone through all votes, check if we have any options
+		// that have received more than half of the possible votes
+		for _, count := range results {
+			// If so, we're done
+			// This means we won't randomly mess with ties
+			if count*2 >= voteCount {
+				for k, c := range results {
+					finalResult[k] = c
+				}
+				return finalResult
+			}
+		}
+		// If no option has won yet, find the option with the least votes and eliminate
+		// it, noting the number of votes it recieved at the time
+		options := make([]string, 0, len(finalResult))
+		for key := range results {
+			options = append(options, key)
+		}
+		// Ties for the fewest votes are broken by name, so a tally always comes out the same
+		sort.Slice(options, func(i, j int) bool {
+			if results[options[i]] != results[options[j]] {
+				return results[options[i]] < results[options[j]]
+			}
+			return options[i] < options[j]
+		})
+
+		finalResult[options[0]] = results[options[0]]
+	}
+}
+
+// outcomeOf finds the option with the most votes, or OUTCOME_TIE if the lead is shared
+func outcomeOf(results map[string]int) string {
+	outcome, best := "", 0
+	for option, count := range results {
+		if count > best {
+			outcome, best = option, count
+		} else if count == best && count > 0 {
+			outcome = OUTCOME_TIE
+		}
+	}
+	return outcome
+}
+
+func containsKey(arr map[string]int, val string) bool {
+	for key, _ := range arr {
+		if key == val {
+			return true
+		}
+	}
+	return false
+}
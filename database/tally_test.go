@@ -0,0 +1,109 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+// ranking builds a ballot ranking the options in the order given
+func ranking(options ...string) map[string]int {
+	ranks := make(map[string]int)
+	for i, option := range options {
+		ranks[option] = i + 1
+	}
+	return ranks
+}
+
+func TestTallyRanked(t *testing.T) {
+	tests := []struct {
+		name     string
+		rankings []map[string]int
+		want     map[string]int
+	}{
+		{
+			name:     "majority in the first round",
+			rankings: []map[string]int{ranking("A", "B"), ranking("A", "B"), ranking("A"), ranking("B", "A")},
+			want:     map[string]int{"A": 3, "B": 1},
+		},
+		{
+			name: "fewest votes eliminated first",
+			rankings: []map[string]int{
+				ranking("A"), ranking("A"),
+				ranking("B"), ranking("B"),
+				ranking("C", "B"),
+			},
+			want: map[string]int{"A": 2, "B": 3, "C": 1},
+		},
+		{
+			name: "eliminated option's votes move to the next preference",
+			rankings: []map[string]int{
+				ranking("A", "C"), ranking("A", "C"), ranking("A", "C"),
+				ranking("B", "C"), ranking("B", "C"), ranking("B", "C"), ranking("B", "C"),
+				ranking("C", "A"), ranking("C", "A"),
+				ranking("D", "A"),
+			},
+			want: map[string]int{"A": 6, "B": 4, "C": 2, "D": 1},
+		},
+		{
+			name:     "ties for fewest broken by name",
+			rankings: []map[string]int{ranking("A", "B"), ranking("B"), ranking("C"), ranking("C"), ranking("D")},
+			want:     map[string]int{"A": 1, "B": 2, "C": 2, "D": 1},
+		},
+		{
+			name:     "every ballot exhausted",
+			rankings: []map[string]int{ranking("A"), ranking("B"), ranking("C")},
+			want:     map[string]int{"A": 1, "B": 1, "C": 1},
+		},
+		{
+			name:     "no ballots",
+			rankings: nil,
+			want:     map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tallyRanked(tt.rankings); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tallyRanked() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTallyRankedEqualRanks(t *testing.T) {
+	// A BLT ballot of "1 1=2 0" ranks A and B equally, and counts for A. Map
+	// order changes from run to run, so tally it enough times to notice
+	rankings := []map[string]int{{"B": 1, "A": 1}, ranking("B"), ranking("C")}
+	want := map[string]int{"A": 1, "B": 2, "C": 1}
+	for i := 0; i < 50; i++ {
+		if got := tallyRanked(rankings); !reflect.DeepEqual(got, want) {
+			t.Fatalf("tallyRanked() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTallySimple(t *testing.T) {
+	got := tallySimple([]string{"Pass", "Fail", "Abstain"}, []string{"Pass", "Pass", "Fail", "Write-in"})
+	want := map[string]int{"Pass": 2, "Fail": 1, "Abstain": 0, "Write-in": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tallySimple() = %v, want %v", got, want)
+	}
+}
+
+func TestOutcomeOf(t *testing.T) {
+	tests := []struct {
+		results map[string]int
+		want    string
+	}{
+		{map[string]int{"Pass": 3, "Fail": 1}, "Pass"},
+		{map[string]int{"Pass": 2, "Fail": 2, "Abstain": 1}, OUTCOME_TIE},
+		{map[string]int{"Pass": 0, "Fail": 0}, ""},
+		{map[string]int{}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := outcomeOf(tt.results); got != tt.want {
+			t.Errorf("outcomeOf(%v) = %q, want %q", tt.results, got, tt.want)
+		}
+	}
+}
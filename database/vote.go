@@ -2,13 +2,13 @@ package database
 
 import (
 	"context"
-	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func HasVoted(pollId, userId string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+func HasVoted(ctx context.Context, pollId, userId string) (bool, error) {
+	ctx, cancel := begin(ctx, "HasVoted")
 	defer cancel()
 
 	pId, err := primitive.ObjectIDFromHex(pollId)
@@ -16,10 +16,59 @@ func HasVoted(pollId, userId string) (bool, error) {
 		return false, err
 	}
 
-	count, err := Client.Database("vote").Collection("votes").CountDocuments(ctx, map[string]interface{}{"pollId": pId, "userId": userId})
+	count, err := Collection("votes").CountDocuments(ctx, map[string]interface{}{"pollId": pId, "userId": userId})
 	if err != nil {
 		return false, err
 	}
 
 	return count > 0, nil
 }
+
+func CountVotes(ctx context.Context, pollId string) (int64, error) {
+	ctx, cancel := begin(ctx, "CountVotes")
+	defer cancel()
+
+	pId, err := primitive.ObjectIDFromHex(pollId)
+	if err != nil {
+		return 0, err
+	}
+
+	return Collection("votes").CountDocuments(ctx, map[string]interface{}{"pollId": pId})
+}
+
+// markVoted flags the poll as having ballots before one is stored, which stops
+// any edit to the ballot that hasn't been saved yet
+func markVoted(ctx context.Context, pollId primitive.ObjectID) error {
+	_, err := Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": pollId}, map[string]interface{}{"$set": map[string]interface{}{"voted": true}})
+	return err
+}
+
+// Ballot is a vote of either type, as stored
+type Ballot struct {
+	UserId  string         `bson:"userId"`
+	Option  string         `bson:"option,omitempty"`
+	Options map[string]int `bson:"options,omitempty"`
+}
+
+// GetBallots returns every ballot cast in a poll, in the order they were cast
+func GetBallots(ctx context.Context, pollId string) ([]Ballot, error) {
+	ctx, cancel := begin(ctx, "GetBallots")
+	defer cancel()
+
+	pId, err := primitive.ObjectIDFromHex(pollId)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := Collection("votes").Find(ctx, map[string]interface{}{"pollId": pId}, options.Find().SetSort(map[string]interface{}{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var ballots []Ballot
+	if err := cursor.All(ctx, &ballots); err != nil {
+		return nil, err
+	}
+
+	return ballots, nil
+}
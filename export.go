@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	csh_auth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/gin-gonic/gin"
+)
+
+type exportPoll struct {
+	Id               string    `json:"id"`
+	ShortDescription string    `json:"shortDescription"`
+	LongDescription  string    `json:"longDescription"`
+	CreatedBy        string    `json:"createdBy"`
+	CreatedAt        time.Time `json:"createdAt"`
+	VoteType         string    `json:"voteType"`
+	Options          []string  `json:"options"`
+	Open             bool      `json:"open"`
+	Official         bool      `json:"official"`
+	OpenBallots      bool      `json:"openBallots"`
+}
+
+type exportTally struct {
+	Option string `json:"option"`
+	Votes  int    `json:"votes"`
+}
+
+type exportBallot struct {
+	// Voter is only filled in for polls with open ballots
+	Voter   string         `json:"voter,omitempty"`
+	Option  string         `json:"option,omitempty"`
+	Ranking map[string]int `json:"ranking,omitempty"`
+}
+
+// exportQuestion is the results of one question on a multi-question ballot
+type exportQuestion struct {
+	Id               string        `json:"id"`
+	ShortDescription string        `json:"shortDescription"`
+	VoteType         string        `json:"voteType"`
+	Results          []exportTally `json:"results"`
+	Outcome          string        `json:"outcome,omitempty"`
+}
+
+type exportResult struct {
+	Poll      exportPoll       `json:"poll"`
+	Results   []exportTally    `json:"results"`
+	Questions []exportQuestion `json:"questions,omitempty"`
+	Turnout   int64            `json:"turnout"`
+	Outcome   string           `json:"outcome,omitempty"`
+	Ballots   []exportBallot   `json:"ballots,omitempty"`
+}
+
+// exportResults serves a poll's results as a CSV or JSON download. The format
+// defaults to defaultFormat when the request doesn't ask for one
+func exportResults(defaultFormat string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cl, _ := c.Get("cshauth")
+		claims := cl.(csh_auth.CSHClaims)
+		// Anyone who can see the results page can export the same results
+
+		format := c.DefaultQuery("format", defaultFormat)
+		if format != "csv" && format != "json" {
+			c.JSON(400, gin.H{"error": "format must be csv or json"})
+			return
+		}
+
+		poll, err := database.GetPoll(c, c.Param("id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		canManage := poll.CanManage(claims.UserInfo.Username, claims.UserInfo.Groups)
+		if poll.Hidden && !canManage {
+			c.JSON(403, gin.H{"error": "Result Hidden"})
+			return
+		}
+
+		withBallots := c.Query("ballots") == "true"
+		if withBallots && (!canManage || poll.Open) {
+			c.JSON(403, gin.H{"error": "Only the creator or a manager can export ballots, once the poll is closed"})
+			return
+		}
+		if withBallots && poll.VoteType == database.POLL_TYPE_MULTI {
+			c.JSON(400, gin.H{"error": "Ballots can't be exported from multi-question polls"})
+			return
+		}
+
+		export, err := buildExport(c, poll, withBallots)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		filename := "poll-" + poll.Id
+		if withBallots {
+			filename += "-ballots"
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+"."+format))
+
+		if format == "json" {
+			c.JSON(200, export)
+			return
+		}
+
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(200)
+		w := newCSVWriter(c.Writer)
+		if withBallots {
+			writeBallotsCSV(w, poll, export.Ballots)
+		} else {
+			writeResultsCSV(w, export)
+		}
+		w.Flush()
+	}
+}
+
+func buildExport(ctx context.Context, poll *database.Poll, withBallots bool) (*exportResult, error) {
+	results, err := poll.GetResult(ctx)
+	if err != nil {
+		return nil, err
+	}
+	turnout, err := database.CountVotes(ctx, poll.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &exportResult{
+		Poll: exportPoll{
+			Id:               poll.Id,
+			ShortDescription: poll.ShortDescription,
+			LongDescription:  poll.LongDescription,
+			CreatedBy:        poll.CreatedBy,
+			CreatedAt:        poll.CreatedAt(),
+			VoteType:         poll.VoteType,
+			Options:          poll.Options,
+			Open:             poll.Open,
+			Official:         poll.Official,
+			OpenBallots:      poll.OpenBallots,
+		},
+		Turnout: turnout,
+	}
+	// The outcome isn't final until the poll closes
+	if !poll.Open {
+		export.Outcome = poll.Outcome
+	}
+
+	export.Results = sortedTallies(results)
+
+	if poll.VoteType == database.POLL_TYPE_MULTI {
+		questions, err := getQuestionResults(ctx, poll)
+		if err != nil {
+			return nil, err
+		}
+		for _, question := range questions {
+			q := exportQuestion{
+				Id:               question.Question.Id,
+				ShortDescription: question.Question.ShortDescription,
+				VoteType:         question.Question.VoteType,
+				Results:          sortedTallies(question.Results),
+			}
+			if !poll.Open {
+				q.Outcome = question.Question.Outcome
+			}
+			export.Questions = append(export.Questions, q)
+		}
+	}
+
+	if withBallots {
+		ballots, err := database.GetBallots(ctx, poll.Id)
+		if err != nil {
+			return nil, err
+		}
+		export.Ballots = make([]exportBallot, 0, len(ballots))
+		for _, ballot := range ballots {
+			b := exportBallot{Option: ballot.Option, Ranking: ballot.Options}
+			if poll.OpenBallots {
+				b.Voter = ballot.UserId
+			}
+			export.Ballots = append(export.Ballots, b)
+		}
+		if !poll.OpenBallots {
+			// The order ballots were cast in could be matched up with who voted when,
+			// so secret ballots are put into an order that only depends on their contents
+			sort.Slice(export.Ballots, func(i, j int) bool {
+				return ballotKey(export.Ballots[i]) < ballotKey(export.Ballots[j])
+			})
+		}
+	}
+
+	return export, nil
+}
+
+// sortedTallies lists results from the most votes to the fewest
+func sortedTallies(results map[string]int) []exportTally {
+	tallies := make([]exportTally, 0, len(results))
+	for option, votes := range results {
+		tallies = append(tallies, exportTally{Option: option, Votes: votes})
+	}
+	sort.Slice(tallies, func(i, j int) bool {
+		if tallies[i].Votes != tallies[j].Votes {
+			return tallies[i].Votes > tallies[j].Votes
+		}
+		return tallies[i].Option < tallies[j].Option
+	})
+	return tallies
+}
+
+// ballotKey gives a ballot a sortable representation of its contents
+func ballotKey(ballot exportBallot) string {
+	if ballot.Ranking == nil {
+		return ballot.Option
+	}
+	options := make([]string, 0, len(ballot.Ranking))
+	for option := range ballot.Ranking {
+		options = append(options, option)
+	}
+	sort.Slice(options, func(i, j int) bool {
+		if ballot.Ranking[options[i]] != ballot.Ranking[options[j]] {
+			return ballot.Ranking[options[i]] < ballot.Ranking[options[j]]
+		}
+		return options[i] < options[j]
+	})
+	return fmt.Sprint(options)
+}
+
+// csvWriter writes CSV that's safe to open in a spreadsheet. Poll descriptions,
+// options and usernames come from users, and a spreadsheet would run any of
+// them that look like a formula
+type csvWriter struct {
+	*csv.Writer
+}
+
+func newCSVWriter(w io.Writer) csvWriter {
+	return csvWriter{csv.NewWriter(w)}
+}
+
+// Write writes a row, quoting any cell that a spreadsheet would treat as a formula
+func (w csvWriter) Write(record []string) error {
+	row := make([]string, len(record))
+	for i, cell := range record {
+		row[i] = csvCell(cell)
+	}
+	return w.Writer.Write(row)
+}
+
+// csvCell makes a value that starts like a formula read as text instead
+func csvCell(value string) string {
+	if value != "" && strings.ContainsAny(value[:1], "=+-@\t\r") {
+		return "'" + value
+	}
+	return value
+}
+
+func writeResultsCSV(w csvWriter, export *exportResult) {
+	status := "Closed"
+	if export.Poll.Open {
+		status = "Open"
+	}
+
+	w.Write([]string{"Poll", export.Poll.ShortDescription})
+	w.Write([]string{"Description", export.Poll.LongDescription})
+	w.Write([]string{"Created By", export.Poll.CreatedBy})
+	w.Write([]string{"Created At", export.Poll.CreatedAt.Format(time.RFC3339)})
+	w.Write([]string{"Vote Type", export.Poll.VoteType})
+	w.Write([]string{"Status", status})
+	w.Write([]string{"Turnout", strconv.FormatInt(export.Turnout, 10)})
+	w.Write([]string{"Outcome", export.Outcome})
+	w.Write([]string{})
+	if len(export.Questions) == 0 {
+		w.Write([]string{"Option", "Votes"})
+		for _, tally := range export.Results {
+			w.Write([]string{tally.Option, strconv.Itoa(tally.Votes)})
+		}
+		return
+	}
+
+	for _, question := range export.Questions {
+		w.Write([]string{"Question", question.ShortDescription})
+		w.Write([]string{"Vote Type", question.VoteType})
+		w.Write([]string{"Outcome", question.Outcome})
+		w.Write([]string{"Option", "Votes"})
+		for _, tally := range question.Results {
+			w.Write([]string{tally.Option, strconv.Itoa(tally.Votes)})
+		}
+		w.Write([]string{})
+	}
+}
+
+func writeBallotsCSV(w csvWriter, poll *database.Poll, ballots []exportBallot) {
+	if poll.VoteType == database.POLL_TYPE_SIMPLE {
+		w.Write([]string{"Ballot", "Voter", "Option"})
+		for i, ballot := range ballots {
+			w.Write([]string{strconv.Itoa(i + 1), ballot.Voter, ballot.Option})
+		}
+		return
+	}
+
+	// Every option that was ranked on any ballot gets a column, including write-ins
+	columns := append([]string{}, poll.Options...)
+	for _, ballot := range ballots {
+		for option := range ballot.Ranking {
+			if !containsString(columns, option) {
+				columns = append(columns, option)
+			}
+		}
+	}
+	sort.Strings(columns[len(poll.Options):])
+
+	w.Write(append([]string{"Ballot", "Voter"}, columns...))
+	for i, ballot := range ballots {
+		row := []string{strconv.Itoa(i + 1), ballot.Voter}
+		for _, option := range columns {
+			rank := ""
+			if r, ok := ballot.Ranking[option]; ok {
+				rank = strconv.Itoa(r)
+			}
+			row = append(row, rank)
+		}
+		w.Write(row)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+
+	"github.com/computersciencehouse/vote/database"
+)
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"Pass", "Pass"},
+		{"12", "12"},
+		{"a=b", "a=b"},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tTab", "'\tTab"},
+	}
+
+	for _, test := range tests {
+		if got := csvCell(test.value); got != test.want {
+			t.Errorf("csvCell(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestWriteBallotsCSVEscapesFormulas(t *testing.T) {
+	var out bytes.Buffer
+	w := newCSVWriter(&out)
+	poll := &database.Poll{VoteType: database.POLL_TYPE_SIMPLE}
+	writeBallotsCSV(w, poll, []exportBallot{
+		{Voter: "user", Option: "=1+1"},
+		{Voter: "@admin", Option: "Pass"},
+	})
+	w.Flush()
+
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Ballot", "Voter", "Option"},
+		{"1", "user", "'=1+1"},
+		{"2", "'@admin", "Pass"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("wrote %q, want %q", rows, want)
+	}
+}
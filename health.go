@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// unavailableRetry is how many seconds clients are asked to wait while the
+// database is unavailable
+const unavailableRetry = "10"
+
+// readyTimeout is kept short so a slow database fails the check rather than
+// the prober giving up on it
+const readyTimeout = 2 * time.Second
+
+// healthz answers as long as the process is up
+func healthz(c *gin.Context) {
+	c.JSON(200, gin.H{"status": "ok"})
+}
+
+// readyz answers with 503 unless the database answers a ping and the broker is
+// running, so instances that can't serve requests are taken out of rotation
+func readyz(broker *sse.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := 200
+		checks := gin.H{"database": "ok", "broker": "ok"}
+
+		ctx, cancel := context.WithTimeout(c, readyTimeout)
+		defer cancel()
+		if err := database.Ping(ctx); err != nil {
+			status = 503
+			checks["database"] = err.Error()
+		}
+		if !broker.Running() {
+			status = 503
+			checks["broker"] = "not running"
+		}
+
+		c.JSON(status, checks)
+	}
+}
+
+// unavailable turns a request away while the database is unreachable. Pages
+// get an explanation, everything else gets JSON
+func unavailable(c *gin.Context) {
+	c.Header("Retry-After", unavailableRetry)
+	path := c.Request.URL.Path
+	if !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/stream/") && !strings.HasPrefix(path, "/ws/") {
+		c.HTML(503, "unavailable.tmpl", gin.H{})
+		return
+	}
+	c.JSON(503, gin.H{"error": "The database is unavailable, try again shortly"})
+}
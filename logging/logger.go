@@ -1,27 +1,68 @@
 package logging
 
 import (
+	"context"
+	"fmt"
 	"os"
-	"runtime"
 
 	"github.com/sirupsen/logrus"
 )
 
 var Logger = &logrus.Logger{
-	Out: os.Stdout,
-	Formatter: &logrus.TextFormatter{
-		DisableLevelTruncation: true,
-		PadLevelText:           true,
-		FullTimestamp:          true,
-	},
-	Hooks: make(logrus.LevelHooks),
-	Level: logrus.InfoLevel,
+	Out:       os.Stdout,
+	Formatter: &logrus.JSONFormatter{},
+	Hooks:     make(logrus.LevelHooks),
+	Level:     logrus.InfoLevel,
 }
 
-func Trace() runtime.Frame {
-	pc := make([]uintptr, 15)
-	n := runtime.Callers(2, pc)
-	frames := runtime.CallersFrames(pc[:n])
-	frame, _ := frames.Next()
-	return frame
+// Configure sets the level and format of every log entry. The format is json,
+// for log collectors, or text, for reading in a terminal
+func Configure(level, format string) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		Logger.SetFormatter(&logrus.JSONFormatter{})
+	case "text":
+		Logger.SetFormatter(&logrus.TextFormatter{
+			DisableLevelTruncation: true,
+			PadLevelText:           true,
+			FullTimestamp:          true,
+		})
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	Logger.SetLevel(parsed)
+	return nil
+}
+
+type fieldsKey struct{}
+
+// NewContext returns a copy of ctx whose log entries carry fields, along with
+// any fields ctx already carries
+func NewContext(ctx context.Context, fields logrus.Fields) context.Context {
+	merged := make(logrus.Fields)
+	if existing, ok := ctx.Value(fieldsKey{}).(logrus.Fields); ok {
+		for key, value := range existing {
+			merged[key] = value
+		}
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// FromContext returns an entry carrying the fields added to ctx, such as the
+// id of the request it belongs to
+func FromContext(ctx context.Context) *logrus.Entry {
+	if ctx != nil {
+		if fields, ok := ctx.Value(fieldsKey{}).(logrus.Fields); ok {
+			return Logger.WithFields(fields)
+		}
+	}
+	return logrus.NewEntry(Logger)
 }
@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIdHeader carries a request's id, both from a proxy that assigned one
+// already and back to the client
+const RequestIdHeader = "X-Request-ID"
+
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Middleware gives every request an id, which is added to the request
+// context's log fields along with the request's trace id, if it's traced, and
+// logs each request once it's done. Use it with gin.Context.ContextWithFallback,
+// so the gin context can be passed on as the request context
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestId := c.GetHeader(RequestIdHeader)
+		if !validRequestId.MatchString(requestId) {
+			requestId = newRequestId()
+		}
+		c.Header(RequestIdHeader, requestId)
+		fields := logrus.Fields{"request_id": requestId}
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			fields["trace_id"] = span.TraceID().String()
+		}
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), fields))
+
+		c.Next()
+
+		// Handlers may have added fields, such as the user, to the request context
+		entry := FromContext(c.Request.Context()).WithFields(logrus.Fields{
+			"module":      "http",
+			"http_method": c.Request.Method,
+			"path":        c.Request.URL.Path,
+			"route":       c.FullPath(),
+			"status":      c.Writer.Status(),
+			"latency_ms":  time.Since(start).Milliseconds(),
+			"client_ip":   c.ClientIP(),
+		})
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("error", c.Errors.String())
+		}
+
+		switch status := c.Writer.Status(); {
+		case status >= 500:
+			entry.Error("request failed")
+		case status >= 400:
+			entry.Warn("request rejected")
+		default:
+			entry.Info("request")
+		}
+	}
+}
+
+func newRequestId() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
@@ -1,85 +1,202 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
-	"sort"
+	"os/signal"
+	"reflect"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode"
 
 	csh_auth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/config"
+	"github.com/computersciencehouse/vote/csrf"
 	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/computersciencehouse/vote/metrics"
 	"github.com/computersciencehouse/vote/sse"
+	"github.com/computersciencehouse/vote/tracing"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func main() {
-	r := gin.Default()
+	cfg, printConfig, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if printConfig {
+		if err := cfg.Print(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := logging.Configure(cfg.Log.Level, cfg.Log.Format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.Endpoint)
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "main", "method": "main"}).Fatal("error setting up tracing")
+	}
+
+	// The database doesn't need to be up yet: database.Monitor keeps trying, and
+	// requests are turned away until it's reachable
+	if err := database.Connect(cfg.Database); err != nil {
+		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "main", "method": "main"}).Fatal("error setting up database client")
+	}
+
+	// Gin's own output goes through our logger, and each request is logged by logging.Middleware
+	gin.DefaultWriter = logging.Logger.WriterLevel(logrus.DebugLevel)
+	gin.DefaultErrorWriter = logging.Logger.WriterLevel(logrus.ErrorLevel)
+	r := gin.New()
+	// Handlers pass their gin context on to the database, so it needs to carry the request context
+	r.ContextWithFallback = true
+	r.Use(otelgin.Middleware(tracing.ServiceName), logging.Middleware(), metrics.Middleware(), gin.Recovery())
+	// Forms and API requests that change anything must send back the browser's CSRF token
+	r.Use(csrf.Middleware(strings.HasPrefix(cfg.Host, "https://")))
 	r.StaticFS("/static", http.Dir("static"))
 	r.LoadHTMLGlob("templates/*")
-	broker := sse.NewBroker()
+	brokerConfig := sse.Config{
+		QueueSize:   cfg.SSE.QueueSize,
+		Overflow:    cfg.SSE.Overflow,
+		HistorySize: cfg.SSE.HistorySize,
+		Heartbeat:   cfg.SSE.Heartbeat,
+	}
+	if cfg.SSE.PubSub == "mongo" {
+		brokerConfig.PubSub = sse.NewMongoPubSub(database.Collection("events"))
+	}
+	broker := sse.NewBroker(brokerConfig)
+	broker.Snapshot = streamSnapshot
+	broker.Authorize = streamAuthorize
+	broker.Identify = streamIdentify
+	metrics.RegisterBroker(broker)
 
 	csh := csh_auth.CSHAuth{}
 	csh.Init(
-		os.Getenv("VOTE_OIDC_ID"),
-		os.Getenv("VOTE_OIDC_SECRET"),
-		os.Getenv("VOTE_JWT_SECRET"),
-		os.Getenv("VOTE_STATE"),
-		os.Getenv("VOTE_HOST"),
-		os.Getenv("VOTE_HOST")+"/auth/callback",
-		os.Getenv("VOTE_HOST")+"/auth/login",
+		cfg.OIDC.ClientId,
+		cfg.OIDC.ClientSecret,
+		cfg.OIDC.JWTSecret,
+		cfg.OIDC.State,
+		cfg.Host,
+		cfg.Host+"/auth/callback",
+		cfg.Host+"/auth/login",
 		[]string{"profile", "email", "groups"},
 	)
 
+	adminGroups = cfg.Groups.Admin
+	officialGroups = cfg.Groups.Official
+	activeGroup = cfg.Groups.Active
+	fallCoopGroup = cfg.Groups.FallCoop
+	springCoopGroup = cfg.Groups.SpringCoop
+
+	// auth requires a CSH login, or an API token for /api/ routes, adding the
+	// user to the request's log fields. Everything behind it needs the
+	// database, so it's turned away while that's down
+	auth := func(handler gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			if !database.Available() {
+				unavailable(c)
+				return
+			}
+
+			// Checking the login gets its own span, which ends when the handler starts
+			ctx := c.Request.Context()
+			authCtx, span := tracing.Tracer.Start(ctx, "auth")
+			defer span.End()
+			c.Request = c.Request.WithContext(authCtx)
+
+			// API clients with a token don't have a session to check
+			if token, ok := csrf.BearerToken(c); ok {
+				claims, err := apiTokenClaims(c, token)
+				span.End()
+				if errors.Is(err, errInvalidAPIToken) {
+					c.JSON(401, gin.H{"error": "Invalid or expired API token"})
+					return
+				} else if err != nil {
+					c.JSON(500, gin.H{"error": err.Error()})
+					return
+				}
+				c.Set("cshauth", claims)
+				c.Request = c.Request.WithContext(logging.NewContext(ctx, logrus.Fields{"user": claims.UserInfo.Username}))
+				handler(c)
+				return
+			}
+
+			csh.AuthWrapper(func(c *gin.Context) {
+				span.End()
+				cl, _ := c.Get("cshauth")
+				claims := cl.(csh_auth.CSHClaims)
+				c.Request = c.Request.WithContext(logging.NewContext(ctx, logrus.Fields{"user": claims.UserInfo.Username}))
+				handler(c)
+			})(c)
+		}
+	}
+
+	r.GET("/metrics", metrics.Handler(cfg.Metrics.Token))
+	r.GET("/healthz", healthz)
+	r.GET("/readyz", readyz(broker))
+
 	r.GET("/auth/login", csh.AuthRequest)
 	r.GET("/auth/callback", csh.AuthCallback)
 	r.GET("/auth/logout", csh.AuthLogout)
 
-	r.GET("/", csh.AuthWrapper(func(c *gin.Context) {
+	r.GET("/", auth(func(c *gin.Context) {
 		cl, _ := c.Get("cshauth")
 		claims := cl.(csh_auth.CSHClaims)
 		// This is intentionally left unprotected
 		// A user may be unable to vote but should still be able to see a list of polls
 
-		polls, err := database.GetOpenPolls()
+		polls, err := database.GetOpenPolls(c)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		sort.Slice(polls, func(i, j int) bool {
-			return polls[i].Id > polls[j].Id
-		})
 
-		closedPolls, err := database.GetClosedVotedPolls(claims.UserInfo.Username)
+		pendingPolls, err := database.GetPendingPolls(c)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		ownedPolls, err := database.GetClosedOwnedPolls(claims.UserInfo.Username)
+
+		closedPolls, err := database.GetClosedPolls(c, claims.UserInfo.Username)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		closedPolls = append(closedPolls, ownedPolls...)
-
-		sort.Slice(closedPolls, func(i, j int) bool {
-			return closedPolls[i].Id > closedPolls[j].Id
-		})
-		closedPolls = uniquePolls(closedPolls)
 
 		c.HTML(200, "index.tmpl", gin.H{
-			"Polls":       polls,
-			"ClosedPolls": closedPolls,
-			"Username":    claims.UserInfo.Username,
-			"FullName":    claims.UserInfo.FullName,
+			"Polls":        polls,
+			"ClosedPolls":  closedPolls,
+			"PendingPolls": pendingPolls,
+			"IsAdmin":      isAdmin(claims.UserInfo.Groups),
+			"Username":     claims.UserInfo.Username,
+			"FullName":     claims.UserInfo.FullName,
 		})
 	}))
 
-	r.GET("/create", csh.AuthWrapper(func(c *gin.Context) {
+	r.GET("/create", auth(func(c *gin.Context) {
 		cl, _ := c.Get("cshauth")
 		claims := cl.(csh_auth.CSHClaims)
 		if !canVote(claims.UserInfo.Groups) {
@@ -90,13 +207,32 @@ func main() {
 			return
 		}
 
+		// Polls created for a meeting wait on its agenda until the chair opens them
+		var meeting *database.Meeting
+		if meetingId := c.Query("meeting"); meetingId != "" {
+			var err error
+			if meeting, err = database.GetMeeting(c, meetingId); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			if !meeting.CanChair(claims.UserInfo.Username) {
+				c.JSON(403, gin.H{"error": "Only the chair can add polls to a meeting"})
+				return
+			}
+		}
+
 		c.HTML(200, "create.tmpl", gin.H{
-			"Username": claims.UserInfo.Username,
-			"FullName": claims.UserInfo.FullName,
+			"OptionsPreset":   "pass-fail",
+			"CustomOptions":   []OptionInput{{}, {}},
+			"CanMarkOfficial": canMarkOfficial(claims.UserInfo.Groups),
+			"Meeting":         meeting,
+			"Username":        claims.UserInfo.Username,
+			"FullName":        claims.UserInfo.FullName,
+			"CSRFToken":       csrf.Token(c),
 		})
 	}))
 
-	r.POST("/create", csh.AuthWrapper(func(c *gin.Context) {
+	r.POST("/create", auth(func(c *gin.Context) {
 		cl, _ := c.Get("cshauth")
 		claims := cl.(csh_auth.CSHClaims)
 		if !canVote(claims.UserInfo.Groups) {
@@ -110,51 +246,111 @@ func main() {
 		poll := &database.Poll{
 			Id:               "",
 			CreatedBy:        claims.UserInfo.Username,
-			ShortDescription: c.PostForm("shortDescription"),
-			LongDescription:  c.PostForm("longDescription"),
+			ShortDescription: strings.TrimSpace(c.PostForm("shortDescription")),
+			LongDescription:  strings.TrimSpace(c.PostForm("longDescription")),
 			VoteType:         database.POLL_TYPE_SIMPLE,
 			Open:             true,
 			Hidden:           false,
 			AllowWriteIns:    c.PostForm("allowWriteIn") == "true",
+			OpenBallots:      c.PostForm("openBallots") == "true",
+			Official:         c.PostForm("official") == "true" && canMarkOfficial(claims.UserInfo.Groups),
 		}
 		if c.PostForm("rankedChoice") == "true" {
 			poll.VoteType = database.POLL_TYPE_RANKED
 		}
 
-		switch c.PostForm("options") {
-		case "pass-fail-conditional":
-			poll.Options = []string{"Pass", "Fail/Conditional", "Abstain"}
-		case "fail-conditional":
-			poll.Options = []string{"Fail", "Conditional", "Abstain"}
-		case "custom":
-			poll.Options = []string{}
-			for _, opt := range strings.Split(c.PostForm("customOptions"), ",") {
-				poll.Options = append(poll.Options, strings.TrimSpace(opt))
-				if !containsString(poll.Options, "Abstain") && (poll.VoteType == database.POLL_TYPE_SIMPLE) {
-					poll.Options = append(poll.Options, "Abstain")
-				}
+		var meeting *database.Meeting
+		if meetingId := c.PostForm("meeting"); meetingId != "" {
+			var err error
+			if meeting, err = database.GetMeeting(c, meetingId); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
 			}
-		case "pass-fail":
-		default:
-			poll.Options = []string{"Pass", "Fail", "Abstain"}
+			if !meeting.CanChair(claims.UserInfo.Username) {
+				c.JSON(403, gin.H{"error": "Only the chair can add polls to a meeting"})
+				return
+			}
+			poll.Open = false
+			poll.Pending = true
+			poll.MeetingId = meeting.Id
+			poll.RequireAttendance = c.PostForm("requireAttendance") == "true"
 		}
 
-		pollId, err := database.CreatePoll(poll)
+		errs := make(map[string]string)
+		validateDescription(poll.ShortDescription, errs)
+		if quorum := strings.TrimSpace(c.PostForm("quorum")); meeting != nil && quorum != "" {
+			percent, err := strconv.ParseFloat(quorum, 64)
+			if err != nil || percent < 0 || percent > 100 {
+				errs["quorum"] = "Quorum must be a percentage between 0 and 100"
+			} else {
+				poll.Quorum = percent / 100
+			}
+		}
+
+		customOptions := parseOptionInputs(c)
+		if c.PostForm("options") == "custom" {
+			if validateOptions(customOptions, errs) {
+				applyOptions(poll, customOptions)
+			}
+		} else if preset, ok := optionPresets[c.PostForm("options")]; ok {
+			poll.Options = append([]string{}, preset...)
+		} else {
+			poll.Options = append([]string{}, optionPresets["pass-fail"]...)
+		}
+
+		if len(errs) > 0 {
+			if len(customOptions) == 0 {
+				customOptions = []OptionInput{{}, {}}
+			}
+			c.HTML(400, "create.tmpl", gin.H{
+				"Errors":            errs,
+				"ShortDescription":  poll.ShortDescription,
+				"LongDescription":   poll.LongDescription,
+				"OptionsPreset":     c.PostForm("options"),
+				"CustomOptions":     customOptions,
+				"AllowWriteIn":      poll.AllowWriteIns,
+				"OpenBallots":       poll.OpenBallots,
+				"RankedChoice":      poll.VoteType == database.POLL_TYPE_RANKED,
+				"Official":          poll.Official,
+				"CanMarkOfficial":   canMarkOfficial(claims.UserInfo.Groups),
+				"Meeting":           meeting,
+				"RequireAttendance": poll.RequireAttendance,
+				"Quorum":            c.PostForm("quorum"),
+				"Username":          claims.UserInfo.Username,
+				"FullName":          claims.UserInfo.FullName,
+				"CSRFToken":         csrf.Token(c),
+			})
+			return
+		}
+
+		pollId, err := database.CreatePoll(c, poll)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 
+		logAction(c, "poll.create", logrus.Fields{"poll": pollId, "meeting": poll.MeetingId})
+
+		if meeting != nil {
+			if err := meeting.AddPoll(c, pollId); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			notifyMeeting(c, broker, meeting.Id)
+			c.Redirect(302, "/meeting/"+meeting.Id)
+			return
+		}
+
 		c.Redirect(302, "/poll/"+pollId)
 	}))
 
-	r.GET("/poll/:id", csh.AuthWrapper(func(c *gin.Context) {
+	r.GET("/poll/:id", auth(func(c *gin.Context) {
 		cl, _ := c.Get("cshauth")
 		claims := cl.(csh_auth.CSHClaims)
 		// This is intentionally left unprotected
 		// We will check if a user can vote and redirect them to results if not later
 
-		poll, err := database.GetPoll(c.Param("id"))
+		poll, err := database.GetPoll(c, c.Param("id"))
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
@@ -171,7 +367,7 @@ func main() {
 			return
 		}
 
-		hasVoted, err := database.HasVoted(poll.Id, claims.UserInfo.Username)
+		hasVoted, err := database.HasVoted(c, poll.Id, claims.UserInfo.Username)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
@@ -181,11 +377,36 @@ func main() {
 			return
 		}
 
+		// Members have to check in to the meeting before they can vote in it
+		eligible, err := isEligible(c, poll, claims.UserInfo.Username)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if !eligible {
+			c.Redirect(302, "/meeting/"+poll.MeetingId)
+			return
+		}
+
+		if poll.VoteType == database.POLL_TYPE_MULTI {
+			c.HTML(200, "ballot.tmpl", gin.H{
+				"Id":               poll.Id,
+				"ShortDescription": poll.ShortDescription,
+				"LongDescription":  poll.LongDescription,
+				"Questions":        poll.Questions,
+				"Username":         claims.UserInfo.Username,
+				"FullName":         claims.UserInfo.FullName,
+				"CSRFToken":        csrf.Token(c),
+			})
+			return
+		}
+
 		writeInAdj := 0
 		if poll.AllowWriteIns {
 			writeInAdj = 1
 		}
 		c.HTML(200, "poll.tmpl", gin.H{
+			"Poll":             poll,
 			"Id":               poll.Id,
 			"ShortDescription": poll.ShortDescription,
 			"LongDescription":  poll.LongDescription,
@@ -195,9 +416,10 @@ func main() {
 			"AllowWriteIns":    poll.AllowWriteIns,
 			"Username":         claims.UserInfo.Username,
 			"FullName":         claims.UserInfo.FullName,
+			"CSRFToken":        csrf.Token(c),
 		})
 	}))
-	r.POST("/poll/:id", csh.AuthWrapper(func(c *gin.Context) {
+	r.POST("/poll/:id", auth(func(c *gin.Context) {
 		cl, _ := c.Get("cshauth")
 		claims := cl.(csh_auth.CSHClaims)
 		if !canVote(claims.UserInfo.Groups) {
@@ -208,13 +430,13 @@ func main() {
 			return
 		}
 
-		poll, err := database.GetPoll(c.Param("id"))
+		poll, err := database.GetPoll(c, c.Param("id"))
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 
-		hasVoted, err := database.HasVoted(poll.Id, claims.UserInfo.Username)
+		hasVoted, err := database.HasVoted(c, poll.Id, claims.UserInfo.Username)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
@@ -224,6 +446,16 @@ func main() {
 			return
 		}
 
+		eligible, err := isEligible(c, poll, claims.UserInfo.Username)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if !eligible {
+			c.JSON(403, gin.H{"error": "You need to check in to the meeting before voting"})
+			return
+		}
+
 		pId, err := primitive.ObjectIDFromHex(poll.Id)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
@@ -246,7 +478,10 @@ func main() {
 				c.JSON(400, gin.H{"error": "Invalid Option"})
 				return
 			}
-			database.CastSimpleVote(&vote)
+			if err := database.CastSimpleVote(c, &vote); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
 		} else if poll.VoteType == database.POLL_TYPE_RANKED {
 			vote := database.RankedVote{
 				Id:      "",
@@ -258,7 +493,8 @@ func main() {
 				if c.PostForm(opt) != "" {
 					rank, err := strconv.Atoi(c.PostForm(opt))
 					if err != nil {
-						c.JSON(500, gin.H{"error": "error parsing votes"})
+						c.JSON(400, gin.H{"error": "error parsing votes"})
+						return
 					}
 					if rank > 0 {
 						vote.Options[opt] = rank
@@ -268,164 +504,552 @@ func main() {
 			if c.PostForm("writeinOption") != "" && c.PostForm("writein") != "" {
 				rank, err := strconv.Atoi(c.PostForm("writein"))
 				if err != nil {
-					c.JSON(500, gin.H{"error": "error parsing votes"})
+					c.JSON(400, gin.H{"error": "error parsing votes"})
+					return
 				}
 				if rank > 0 {
 					vote.Options[c.PostForm("writeinOption")] = rank
 				}
 			}
-			database.CastRankedVote(&vote)
+			if err := database.CastRankedVote(c, &vote); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+		} else if poll.VoteType == database.POLL_TYPE_MULTI {
+			// Every question is answered in one vote, so the whole ballot is cast or none of it is
+			answers, err := parseMultiVote(c, poll)
+			if err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+			vote := database.MultiVote{
+				PollId:  pId,
+				UserId:  claims.UserInfo.Username,
+				Answers: answers,
+			}
+			if err := database.CastMultiVote(c, &vote); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
 		} else {
 			c.JSON(500, gin.H{"error": "Unknown Poll Type"})
 			return
 		}
 
-		if poll, err := database.GetPoll(c.Param("id")); err == nil {
-			if results, err := poll.GetResult(); err == nil {
-				if bytes, err := json.Marshal(results); err == nil {
-					broker.Notifier <- sse.NotificationEvent{
-						EventName: poll.Id,
-						Payload:   string(bytes),
-					}
-				}
-
-			}
-		}
+		logAction(c, "poll.vote", logrus.Fields{"poll": poll.Id})
+		metrics.BallotCast(poll.VoteType)
+		notifyBallotCast(c, broker, poll)
 
 		c.Redirect(302, "/results/"+poll.Id)
 	}))
 
-	r.GET("/results/:id", csh.AuthWrapper(func(c *gin.Context) {
+	r.GET("/results/:id", auth(func(c *gin.Context) {
 		cl, _ := c.Get("cshauth")
 		claims := cl.(csh_auth.CSHClaims)
 		// This is intentionally left unprotected
 		// A user may be unable to vote but still interested in the results of a poll
 
-		poll, err := database.GetPoll(c.Param("id"))
+		poll, err := database.GetPoll(c, c.Param("id"))
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 
-        if poll.Hidden && poll.CreatedBy != claims.UserInfo.Username {
-            c.JSON(403, gin.H{"error": "Result Hidden"})
-            return
-        }
+		canManage := poll.CanManage(claims.UserInfo.Username, claims.UserInfo.Groups)
+		if poll.Hidden && !canManage {
+			c.JSON(403, gin.H{"error": "Result Hidden"})
+			return
+		}
+
+		results, err := poll.GetResult(c)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		quorum, err := getQuorumStatus(c, poll)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
 
-		results, err := poll.GetResult()
+		turnout, err := database.CountVotes(c, poll.Id)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 
+		var questions []questionResult
+		if poll.VoteType == database.POLL_TYPE_MULTI {
+			if questions, err = getQuestionResults(c, poll); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
 		c.HTML(200, "result.tmpl", gin.H{
 			"Id":               poll.Id,
 			"ShortDescription": poll.ShortDescription,
 			"LongDescription":  poll.LongDescription,
 			"Results":          results,
 			"IsOpen":           poll.Open,
+			"IsPending":        poll.Pending,
+			"OpensAt":          poll.OpensAt,
+			"IsRanked":         poll.VoteType == database.POLL_TYPE_RANKED,
+			"IsMulti":          poll.VoteType == database.POLL_TYPE_MULTI,
             "IsHidden":         poll.Hidden,
+			"MeetingId":        poll.MeetingId,
+			"Quorum":           quorum,
+			"Questions":        questions,
+			"Turnout":          turnout,
+			"CanManage":        canManage,
 			"IsOwner":          poll.CreatedBy == claims.UserInfo.Username,
 			"Username":         claims.UserInfo.Username,
 			"FullName":         claims.UserInfo.FullName,
+			"CSRFToken":        csrf.Token(c),
+		})
+	}))
+
+	r.GET("/poll/:id/edit", auth(func(c *gin.Context) {
+		cl, _ := c.Get("cshauth")
+		claims := cl.(csh_auth.CSHClaims)
+
+		poll, err := database.GetPoll(c, c.Param("id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !poll.CanManage(claims.UserInfo.Username, claims.UserInfo.Groups) {
+			c.JSON(403, gin.H{"error": "Only the creator or a manager can edit a poll"})
+			return
+		}
+		if poll.VoteType == database.POLL_TYPE_MULTI {
+			c.JSON(400, gin.H{"error": "Multi-question ballots can't be edited"})
+			return
+		}
+
+		votes, err := database.CountVotes(c, poll.Id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		customOptions := make([]OptionInput, 0, len(poll.Options))
+		for _, opt := range poll.Options {
+			customOptions = append(customOptions, OptionInput{Name: opt, Description: poll.DescriptionFor(opt)})
+		}
+
+		c.HTML(200, "edit.tmpl", gin.H{
+			"Id":               poll.Id,
+			"ShortDescription": poll.ShortDescription,
+			"LongDescription":  poll.LongDescription,
+			"CustomOptions":    customOptions,
+			"AllowWriteIn":     poll.AllowWriteIns,
+			"OpenBallots":      poll.OpenBallots,
+			"RankedChoice":     poll.VoteType == database.POLL_TYPE_RANKED,
+			"Official":         poll.Official,
+			"CanMarkOfficial":  canMarkOfficial(claims.UserInfo.Groups),
+			"Options":          poll.Options,
+			"HasVotes":         votes > 0,
+			"History":          poll.History,
+			"Username":         claims.UserInfo.Username,
+			"FullName":         claims.UserInfo.FullName,
+			"CSRFToken":        csrf.Token(c),
 		})
 	}))
 
-    r.POST("/poll/:id/hide", csh.AuthWrapper(func(c *gin.Context) {
+	r.POST("/poll/:id/edit", auth(func(c *gin.Context) {
+		cl, _ := c.Get("cshauth")
+		claims := cl.(csh_auth.CSHClaims)
+
+		poll, err := database.GetPoll(c, c.Param("id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !poll.CanManage(claims.UserInfo.Username, claims.UserInfo.Groups) {
+			c.JSON(403, gin.H{"error": "Only the creator or a manager can edit a poll"})
+			return
+		}
+		if poll.VoteType == database.POLL_TYPE_MULTI {
+			c.JSON(400, gin.H{"error": "Multi-question ballots can't be edited"})
+			return
+		}
+
+		votes, err := database.CountVotes(c, poll.Id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		edited := *poll
+		edited.ShortDescription = strings.TrimSpace(c.PostForm("shortDescription"))
+		edited.LongDescription = strings.TrimSpace(c.PostForm("longDescription"))
+
+		if canMarkOfficial(claims.UserInfo.Groups) {
+			edited.Official = c.PostForm("official") == "true"
+		}
+
+		errs := make(map[string]string)
+		validateDescription(edited.ShortDescription, errs)
+
+		customOptions := parseOptionInputs(c)
+		if votes == 0 {
+			edited.AllowWriteIns = c.PostForm("allowWriteIn") == "true"
+			edited.OpenBallots = c.PostForm("openBallots") == "true"
+			edited.VoteType = database.POLL_TYPE_SIMPLE
+			if c.PostForm("rankedChoice") == "true" {
+				edited.VoteType = database.POLL_TYPE_RANKED
+			}
+			if validateOptions(customOptions, errs) {
+				applyOptions(&edited, customOptions)
+			}
+		} else if len(customOptions) > 0 {
+			// Ballots were cast while the form was open, so the options can no longer change
+			errs["options"] = "Ballots have been cast, so only the descriptions can be changed"
+		}
+
+		if len(errs) > 0 {
+			c.HTML(400, "edit.tmpl", gin.H{
+				"Errors":           errs,
+				"Id":               poll.Id,
+				"ShortDescription": edited.ShortDescription,
+				"LongDescription":  edited.LongDescription,
+				"CustomOptions":    customOptions,
+				"AllowWriteIn":     c.PostForm("allowWriteIn") == "true",
+				"OpenBallots":      c.PostForm("openBallots") == "true",
+				"RankedChoice":     c.PostForm("rankedChoice") == "true",
+				"Official":         c.PostForm("official") == "true",
+				"CanMarkOfficial":  canMarkOfficial(claims.UserInfo.Groups),
+				"Options":          poll.Options,
+				"HasVotes":         votes > 0,
+				"History":          poll.History,
+				"Username":         claims.UserInfo.Username,
+				"FullName":         claims.UserInfo.FullName,
+				"CSRFToken":        csrf.Token(c),
+			})
+			return
+		}
+
+		changes := pollChanges(poll, &edited)
+		if len(changes) == 0 {
+			c.Redirect(302, "/results/"+poll.Id)
+			return
+		}
+
+		err = edited.Edit(c, database.HistoryEntry{
+			Time:    time.Now(),
+			User:    claims.UserInfo.Username,
+			Action:  "edit",
+			Details: strings.Join(changes, "; "),
+		}, changesBallot(poll, &edited))
+		if errors.Is(err, database.ErrBallotsCast) {
+			c.JSON(409, gin.H{"error": "Ballots were cast while you were editing, so the options can no longer change"})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		logAction(c, "poll.edit", logrus.Fields{"poll": poll.Id})
+		notifyEdited(c, broker, poll)
+
+		c.Redirect(302, "/results/"+poll.Id)
+	}))
+
+	r.GET("/poll/:id/managers", auth(func(c *gin.Context) {
+		cl, _ := c.Get("cshauth")
+		claims := cl.(csh_auth.CSHClaims)
+
+		poll, err := database.GetPoll(c, c.Param("id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if poll.CreatedBy != claims.UserInfo.Username {
+			c.JSON(403, gin.H{"error": "Only the creator can change a poll's managers"})
+			return
+		}
+
+		c.HTML(200, "managers.tmpl", gin.H{
+			"Id":               poll.Id,
+			"ShortDescription": poll.ShortDescription,
+			"Managers":         strings.Join(poll.Managers, ", "),
+			"ManagerGroups":    strings.Join(poll.ManagerGroups, ", "),
+			"Username":         claims.UserInfo.Username,
+			"FullName":         claims.UserInfo.FullName,
+			"CSRFToken":        csrf.Token(c),
+		})
+	}))
+
+	r.POST("/poll/:id/managers", auth(func(c *gin.Context) {
+		cl, _ := c.Get("cshauth")
+		claims := cl.(csh_auth.CSHClaims)
+
+		poll, err := database.GetPoll(c, c.Param("id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if poll.CreatedBy != claims.UserInfo.Username {
+			c.JSON(403, gin.H{"error": "Only the creator can change a poll's managers"})
+			return
+		}
+
+		managers := splitList(c.PostForm("managers"))
+		managerGroups := splitList(c.PostForm("managerGroups"))
+
+		err = poll.SetManagers(c, managers, managerGroups, database.HistoryEntry{
+			Time:    time.Now(),
+			User:    claims.UserInfo.Username,
+			Action:  "managers",
+			Details: fmt.Sprintf("users %q, groups %q", managers, managerGroups),
+		})
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		logAction(c, "poll.managers", logrus.Fields{"poll": poll.Id})
+		c.Redirect(302, "/results/"+poll.Id)
+	}))
+
+    r.POST("/poll/:id/hide", auth(func(c *gin.Context) {
         cl, _ := c.Get("cshauth")
         claims := cl.(csh_auth.CSHClaims)
 
-        poll, err := database.GetPoll(c.Param("id"))
+        poll, err := database.GetPoll(c, c.Param("id"))
 
         if err != nil {
             c.JSON(500, gin.H{"error": err.Error()})
             return
         }
 
-        if poll.CreatedBy != claims.UserInfo.Username {
-            c.JSON(403, gin.H{"error": "Only the creator can hide a poll result"})
+        if !poll.CanManage(claims.UserInfo.Username, claims.UserInfo.Groups) {
+            c.JSON(403, gin.H{"error": "Only the creator or a manager can hide a poll result"})
             return
         }
 
-        err = poll.Hide()
+        err = poll.Hide(c)
         if err != nil {
             c.JSON(500, gin.H{"error": err.Error()})
             return
         }
+        logAction(c, "poll.hide", logrus.Fields{"poll": poll.Id})
+        notifyVisibility(c, broker, poll)
 
         c.Redirect(302, "/results/" + poll.Id)
     }))
 
 
-    r.POST("/poll/:id/reveal", csh.AuthWrapper(func(c *gin.Context) {
+    r.POST("/poll/:id/reveal", auth(func(c *gin.Context) {
         cl, _ := c.Get("cshauth")
         claims := cl.(csh_auth.CSHClaims)
 
-        poll, err := database.GetPoll(c.Param("id"))
+        poll, err := database.GetPoll(c, c.Param("id"))
 
         if err != nil {
             c.JSON(500, gin.H{"error": err.Error()})
             return
         }
 
-        if poll.CreatedBy != claims.UserInfo.Username {
-            c.JSON(403, gin.H{"error": "Only the creator can reveal a poll result"})
+        if !poll.CanManage(claims.UserInfo.Username, claims.UserInfo.Groups) {
+            c.JSON(403, gin.H{"error": "Only the creator or a manager can reveal a poll result"})
             return
         }
 
-        err = poll.Reveal()
+        err = poll.Reveal(c)
         if err != nil {
             c.JSON(500, gin.H{"error": err.Error()})
             return
         }
+        logAction(c, "poll.reveal", logrus.Fields{"poll": poll.Id})
+        notifyVisibility(c, broker, poll)
 
         c.Redirect(302, "/results/" + poll.Id)
     }))
 
-	r.POST("/poll/:id/close", csh.AuthWrapper(func(c *gin.Context) {
+	r.POST("/poll/:id/open", auth(func(c *gin.Context) {
+		cl, _ := c.Get("cshauth")
+		claims := cl.(csh_auth.CSHClaims)
+
+		poll, err := database.GetPoll(c, c.Param("id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !poll.CanManage(claims.UserInfo.Username, claims.UserInfo.Groups) {
+			c.JSON(403, gin.H{"error": "Only the creator or a manager can open a poll"})
+			return
+		}
+
+		if !poll.Pending {
+			c.JSON(400, gin.H{"error": "Only a poll that hasn't opened yet can be opened"})
+			return
+		}
+
+		err = poll.Start(c)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		logAction(c, "poll.open", logrus.Fields{"poll": poll.Id})
+		notifyOpened(c, broker, poll)
+		notifyMeeting(c, broker, poll.MeetingId)
+
+		c.Redirect(302, "/results/"+poll.Id)
+	}))
+
+	r.POST("/poll/:id/close", auth(func(c *gin.Context) {
 		cl, _ := c.Get("cshauth")
 		claims := cl.(csh_auth.CSHClaims)
 		// This is intentionally left unprotected
-		// A user should be able to close their own polls, regardless of if they can vote
+		// A user should be able to close polls they manage, regardless of if they can vote
 
-		poll, err := database.GetPoll(c.Param("id"))
+		poll, err := database.GetPoll(c, c.Param("id"))
 
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 
-		if poll.CreatedBy != claims.UserInfo.Username {
-			c.JSON(403, gin.H{"error": "Only the creator can close a poll"})
+		if !poll.CanManage(claims.UserInfo.Username, claims.UserInfo.Groups) {
+			c.JSON(403, gin.H{"error": "Only the creator or a manager can close a poll"})
 			return
 		}
 
-		err = poll.Close()
+		err = poll.Close(c)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
+		logAction(c, "poll.close", logrus.Fields{"poll": poll.Id})
+		notifyClosed(c, broker, poll)
+		notifyMeeting(c, broker, poll.MeetingId)
 
 		c.Redirect(302, "/results/"+poll.Id)
 	}))
 
-	r.GET("/stream/:topic", csh.AuthWrapper(broker.ServeHTTP))
+	r.GET("/results/:id/export", auth(exportResults("csv")))
+	r.GET("/api/poll/:id/results", auth(exportResults("json")))
+
+	r.GET("/results/:id/blt", auth(exportBLT))
+	r.GET("/import", auth(importBLTPage))
+	r.POST("/import", auth(importBLT))
+
+	r.GET("/create/agenda", auth(agendaPage))
+	r.POST("/create/agenda", auth(createAgenda))
+	r.POST("/api/agenda", auth(createAgendaAPI))
+	r.GET("/create/ballot", auth(ballotPage))
+	r.POST("/create/ballot", auth(createBallot))
+
+	r.GET("/meetings", auth(meetingsPage))
+	r.POST("/meetings", auth(createMeeting))
+	r.GET("/meeting/:id", auth(meetingPage))
+	r.GET("/meeting/:id/summary", auth(meetingSummary))
+	r.POST("/meeting/:id/agenda", auth(runAgenda(broker)))
+	r.POST("/meeting/:id/checkin", auth(checkIn(broker)))
+	r.POST("/meeting/:id/attendance", auth(markAttendance(broker)))
+	r.GET("/meeting/:id/attendance", auth(exportAttendance))
+
+	r.GET("/archive", auth(archivePage))
+	r.GET("/api/archive", auth(archiveAPI))
+
+	// Scripts using a session cookie send this back in the X-CSRF-Token header
+	r.GET("/api/csrf", auth(func(c *gin.Context) {
+		c.JSON(200, gin.H{"token": csrf.Token(c)})
+	}))
+
+	r.GET("/tokens", auth(listAPITokens))
+	r.POST("/tokens", auth(createAPIToken))
+	r.POST("/tokens/:id/delete", auth(deleteAPIToken))
+
+	r.GET("/admin", auth(adminDashboard))
+	r.POST("/admin/poll/:id", auth(adminOverridePoll(broker)))
+
+	r.GET("/stream/:topic", auth(broker.ServeHTTP))
+	r.GET("/ws/:topic", auth(broker.ServeWebSocket))
+
+	// An interrupt or SIGTERM starts a graceful shutdown. A second one kills
+	// the server straight away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go database.Monitor(ctx)
+	go broker.Listen(ctx)
+	schedulerDone := make(chan struct{})
+	go func() {
+		runScheduler(ctx, broker)
+		close(schedulerDone)
+	}()
+
+	server := &http.Server{Addr: cfg.Addr, Handler: r, ReadHeaderTimeout: cfg.ReadHeaderTimeout}
+	go func() {
+		logging.Logger.WithFields(logrus.Fields{"module": "main", "method": "main", "addr": cfg.Addr}).Info("listening")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logging.Logger.WithFields(logrus.Fields{"error": err, "module": "main", "method": "main"}).Fatal("error serving http")
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	logging.Logger.WithFields(logrus.Fields{"module": "main", "method": "main"}).Info("shutting down")
+
+	// The broker has already started closing every stream, so the requests
+	// serving them finish along with everything else in flight
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "main", "method": "main"}).Error("error shutting down http server")
+	}
+	<-schedulerDone
 
-	go broker.Listen()
+	database.Disconnect()
 
-	r.Run()
+	tracingCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "main", "method": "main"}).Error("error flushing traces")
+	}
+}
+
+// officialGroups are the OIDC groups whose members may mark a poll as official,
+// pinning it to the top of the list of polls
+var officialGroups = []string{"eboard"}
+
+func canMarkOfficial(groups []string) bool {
+	for _, group := range groups {
+		if containsString(officialGroups, group) {
+			return true
+		}
+	}
+	return false
 }
 
+// The OIDC groups that decide who may vote: active members, unless they're
+// on co-op this semester
+var (
+	activeGroup     = "active"
+	fallCoopGroup   = "fall_coop"
+	springCoopGroup = "spring_coop"
+)
+
 func canVote(groups []string) bool {
 	var active, fall_coop, spring_coop bool
 	for _, group := range groups {
-		if group == "active" {
+		if group == activeGroup {
 			active = true
 		}
-		if group == "fall_coop" {
+		if fallCoopGroup != "" && group == fallCoopGroup {
 			fall_coop = true
 		}
-		if group == "spring_coop" {
+		if springCoopGroup != "" && group == springCoopGroup {
 			spring_coop = true
 		}
 	}
@@ -437,23 +1061,57 @@ func canVote(groups []string) bool {
 	}
 }
 
-func uniquePolls(polls []*database.Poll) []*database.Poll {
-	var unique []*database.Poll
-	for _, poll := range polls {
-		if !containsPoll(unique, poll) {
-			unique = append(unique, poll)
-		}
+// pollChanges describes each field that differs between two versions of a poll
+func pollChanges(before, after *database.Poll) []string {
+	var changes []string
+	if before.ShortDescription != after.ShortDescription {
+		changes = append(changes, fmt.Sprintf("short description %q -> %q", before.ShortDescription, after.ShortDescription))
+	}
+	if before.LongDescription != after.LongDescription {
+		changes = append(changes, fmt.Sprintf("long description %q -> %q", before.LongDescription, after.LongDescription))
+	}
+	if before.VoteType != after.VoteType {
+		changes = append(changes, fmt.Sprintf("vote type %s -> %s", before.VoteType, after.VoteType))
+	}
+	if before.Official != after.Official {
+		changes = append(changes, fmt.Sprintf("official %t -> %t", before.Official, after.Official))
+	}
+	if before.OpenBallots != after.OpenBallots {
+		changes = append(changes, fmt.Sprintf("open ballots %t -> %t", before.OpenBallots, after.OpenBallots))
+	}
+	if before.AllowWriteIns != after.AllowWriteIns {
+		changes = append(changes, fmt.Sprintf("write-ins %t -> %t", before.AllowWriteIns, after.AllowWriteIns))
 	}
-	return unique
+	if !reflect.DeepEqual(before.Options, after.Options) {
+		changes = append(changes, fmt.Sprintf("options %q -> %q", before.Options, after.Options))
+	}
+	if !reflect.DeepEqual(before.OptionDescriptions, after.OptionDescriptions) {
+		changes = append(changes, "option descriptions")
+	}
+	return changes
 }
 
-func containsPoll(polls []*database.Poll, poll *database.Poll) bool {
-	for _, p := range polls {
-		if p.Id == poll.Id {
-			return true
+// changesBallot reports whether an edit changes what voters are asked or how
+// their ballots are kept, which can't happen once anyone has voted
+func changesBallot(before, after *database.Poll) bool {
+	return before.VoteType != after.VoteType ||
+		before.AllowWriteIns != after.AllowWriteIns ||
+		before.OpenBallots != after.OpenBallots ||
+		!reflect.DeepEqual(before.Options, after.Options) ||
+		!reflect.DeepEqual(before.OptionDescriptions, after.OptionDescriptions)
+}
+
+// splitList turns a comma or space separated list into its unique, non-empty entries
+func splitList(list string) []string {
+	entries := []string{}
+	for _, entry := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}) {
+		if !containsString(entries, entry) {
+			entries = append(entries, entry)
 		}
 	}
-	return false
+	return entries
 }
 
 func hasOption(poll *database.Poll, option string) bool {
@@ -473,3 +1131,9 @@ func containsString(arr []string, val string) bool {
 	}
 	return false
 }
+
+// logAction records a change made by the request's user, along with the
+// request's own log fields
+func logAction(ctx context.Context, action string, fields logrus.Fields) {
+	logging.FromContext(ctx).WithFields(fields).WithField("action", action).Info("state change")
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	csh_auth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/csrf"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// meetingItem is a poll on a meeting's agenda along with what the current user may see of it
+type meetingItem struct {
+	Poll    *database.Poll
+	Results map[string]int
+	Quorum  *quorumStatus
+	Hidden  bool
+}
+
+// notifyMeeting tells anyone watching a meeting's page that its agenda has changed
+func notifyMeeting(ctx context.Context, broker *sse.Broker, meetingId string) {
+	if meetingId == "" {
+		return
+	}
+	broker.Publish(ctx, sse.NewMeetingEvent(meetingId, sse.EventAgendaChanged, nil))
+}
+
+func meetingsPage(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+	// Anyone may follow along with a meeting, even if they can't vote in it
+
+	meetings, err := database.GetMeetings(c)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.HTML(200, "meetings.tmpl", gin.H{
+		"Meetings":  meetings,
+		"CanCreate": canVote(claims.UserInfo.Groups),
+		"Username":  claims.UserInfo.Username,
+		"FullName":  claims.UserInfo.FullName,
+		"CSRFToken": csrf.Token(c),
+	})
+}
+
+func createMeeting(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+	if !canVote(claims.UserInfo.Groups) {
+		c.HTML(403, "unauthorized.tmpl", gin.H{
+			"Username": claims.UserInfo.Username,
+			"FullName": claims.UserInfo.FullName,
+		})
+		return
+	}
+
+	title := strings.TrimSpace(c.PostForm("title"))
+	if title == "" {
+		c.JSON(400, gin.H{"error": "A meeting needs a title"})
+		return
+	}
+	date, err := time.ParseInLocation("2006-01-02T15:04", c.PostForm("date"), time.Local)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "A meeting needs a valid date"})
+		return
+	}
+	chair := strings.TrimSpace(c.PostForm("chair"))
+	if chair == "" {
+		chair = claims.UserInfo.Username
+	}
+
+	meetingId, err := database.CreateMeeting(c, &database.Meeting{
+		Title:     title,
+		Date:      date,
+		Chair:     chair,
+		CreatedBy: claims.UserInfo.Username,
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	logAction(c, "meeting.create", logrus.Fields{"meeting": meetingId})
+	c.Redirect(302, "/meeting/"+meetingId)
+}
+
+// meetingItems loads a meeting's polls in order, with results for those the user may see
+func meetingItems(ctx context.Context, meeting *database.Meeting, claims csh_auth.CSHClaims, withResults bool) ([]meetingItem, error) {
+	polls, err := meeting.GetPolls(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]meetingItem, 0, len(polls))
+	for _, poll := range polls {
+		item := meetingItem{
+			Poll:   poll,
+			Hidden: poll.Hidden && !poll.CanManage(claims.UserInfo.Username, claims.UserInfo.Groups),
+		}
+		if withResults && !item.Hidden && !poll.Pending {
+			if item.Results, err = poll.GetResult(ctx); err != nil {
+				return nil, err
+			}
+			if item.Quorum, err = getQuorumStatus(ctx, poll); err != nil {
+				return nil, err
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func meetingPage(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+
+	meeting, err := database.GetMeeting(c, c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	items, err := meetingItems(c, meeting, claims, false)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	attendance, err := database.GetAttendance(c, meeting.Id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	isPresent := false
+	for _, record := range attendance {
+		if record.UserId == claims.UserInfo.Username {
+			isPresent = true
+		}
+	}
+
+	c.HTML(200, "meeting.tmpl", gin.H{
+		"Meeting":    meeting,
+		"Items":      items,
+		"Attendance": attendance,
+		"IsPresent":  isPresent,
+		"CanVote":    canVote(claims.UserInfo.Groups),
+		"IsChair":    meeting.CanChair(claims.UserInfo.Username),
+		"Username":   claims.UserInfo.Username,
+		"FullName":   claims.UserInfo.FullName,
+		"CSRFToken":  csrf.Token(c),
+	})
+}
+
+func meetingSummary(c *gin.Context) {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+
+	meeting, err := database.GetMeeting(c, c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	items, err := meetingItems(c, meeting, claims, true)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	present, err := database.CountAttendance(c, meeting.Id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.HTML(200, "meeting_summary.tmpl", gin.H{
+		"Meeting":  meeting,
+		"Items":    items,
+		"Present":  present,
+		"Username": claims.UserInfo.Username,
+		"FullName": claims.UserInfo.FullName,
+	})
+}
+
+// runAgenda lets the chair open and close agenda items, reorder them, or move
+// on to the next item
+func runAgenda(broker *sse.Broker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cl, _ := c.Get("cshauth")
+		claims := cl.(csh_auth.CSHClaims)
+
+		meeting, err := database.GetMeeting(c, c.Param("id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !meeting.CanChair(claims.UserInfo.Username) {
+			c.JSON(403, gin.H{"error": "Only the chair can run the agenda"})
+			return
+		}
+
+		polls, err := meeting.GetPolls(c)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		index := -1
+		for i, poll := range polls {
+			if poll.Id == c.PostForm("poll") {
+				index = i
+			}
+		}
+
+		switch action := c.PostForm("action"); action {
+		case "open", "close":
+			if index < 0 {
+				c.JSON(400, gin.H{"error": "That poll isn't on the agenda"})
+				return
+			}
+			poll := polls[index]
+			if action == "open" && poll.Pending {
+				if err = poll.Start(c); err == nil {
+					logAction(c, "poll.open", logrus.Fields{"poll": poll.Id, "meeting": meeting.Id})
+					notifyOpened(c, broker, poll)
+				}
+			} else if action == "close" && poll.Open {
+				if err = poll.Close(c); err == nil {
+					logAction(c, "poll.close", logrus.Fields{"poll": poll.Id, "meeting": meeting.Id})
+					notifyClosed(c, broker, poll)
+				}
+			}
+		case "advance":
+			// Close whatever is open, then open the next item that hasn't been voted on yet
+			for _, poll := range polls {
+				if poll.Open {
+					if err = poll.Close(c); err != nil {
+						break
+					}
+					logAction(c, "poll.close", logrus.Fields{"poll": poll.Id, "meeting": meeting.Id})
+					notifyClosed(c, broker, poll)
+				}
+			}
+			if err == nil {
+				for _, poll := range polls {
+					if poll.Pending {
+						if err = poll.Start(c); err == nil {
+							logAction(c, "poll.open", logrus.Fields{"poll": poll.Id, "meeting": meeting.Id})
+							notifyOpened(c, broker, poll)
+						}
+						break
+					}
+				}
+			}
+		case "up", "down":
+			agenda := append([]string{}, meeting.Agenda...)
+			position := -1
+			for i, id := range agenda {
+				if id == c.PostForm("poll") {
+					position = i
+				}
+			}
+			other := position - 1
+			if action == "down" {
+				other = position + 1
+			}
+			if position < 0 || other < 0 || other >= len(agenda) {
+				c.Redirect(302, "/meeting/"+meeting.Id)
+				return
+			}
+			agenda[position], agenda[other] = agenda[other], agenda[position]
+			if err = meeting.SetAgenda(c, agenda); err == nil {
+				logAction(c, "meeting.reorder", logrus.Fields{"meeting": meeting.Id, "poll": agenda[other], "direction": action})
+			}
+		default:
+			c.JSON(400, gin.H{"error": "Unknown action"})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		notifyMeeting(c, broker, meeting.Id)
+		c.Redirect(302, "/meeting/"+meeting.Id)
+	}
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"github.com/computersciencehouse/vote/sse"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	sseClientsDesc = prometheus.NewDesc(
+		"vote_sse_clients",
+		"Live clients connected to this instance, by topic.",
+		[]string{"topic"}, nil,
+	)
+	sseDeliveredDesc = prometheus.NewDesc(
+		"vote_sse_events_delivered_total",
+		"Events queued for live clients.",
+		nil, nil,
+	)
+	sseDroppedDesc = prometheus.NewDesc(
+		"vote_sse_events_dropped_total",
+		"Events discarded because a live client's queue was full.",
+		nil, nil,
+	)
+	sseSlowClientsDesc = prometheus.NewDesc(
+		"vote_sse_slow_clients_total",
+		"Times an event found a live client's queue full.",
+		nil, nil,
+	)
+	sseDisconnectedDesc = prometheus.NewDesc(
+		"vote_sse_clients_disconnected_total",
+		"Live clients disconnected for falling behind.",
+		nil, nil,
+	)
+)
+
+// brokerCollector reads a broker's counters whenever metrics are scraped
+type brokerCollector struct {
+	broker *sse.Broker
+}
+
+// RegisterBroker exposes a broker's clients and counters
+func RegisterBroker(broker *sse.Broker) {
+	prometheus.MustRegister(brokerCollector{broker: broker})
+}
+
+func (collector brokerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sseClientsDesc
+	ch <- sseDeliveredDesc
+	ch <- sseDroppedDesc
+	ch <- sseSlowClientsDesc
+	ch <- sseDisconnectedDesc
+}
+
+func (collector brokerCollector) Collect(ch chan<- prometheus.Metric) {
+	for topic, count := range collector.broker.TopicClients() {
+		ch <- prometheus.MustNewConstMetric(sseClientsDesc, prometheus.GaugeValue, float64(count), topic)
+	}
+
+	stats := collector.broker.Stats()
+	ch <- prometheus.MustNewConstMetric(sseDeliveredDesc, prometheus.CounterValue, float64(stats.Delivered))
+	ch <- prometheus.MustNewConstMetric(sseDroppedDesc, prometheus.CounterValue, float64(stats.Dropped))
+	ch <- prometheus.MustNewConstMetric(sseSlowClientsDesc, prometheus.CounterValue, float64(stats.SlowClients))
+	ch <- prometheus.MustNewConstMetric(sseDisconnectedDesc, prometheus.CounterValue, float64(stats.Disconnected))
+}
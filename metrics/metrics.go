@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vote_http_requests_total",
+		Help: "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "vote_http_request_duration_seconds",
+		Help:    "How long HTTP requests took to handle, by route and method. Streams are left out, since they last as long as the client stays.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	ballotsCast = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vote_ballots_cast_total",
+		Help: "Ballots cast, by poll type.",
+	}, []string{"vote_type"})
+
+	resultDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "vote_result_computation_seconds",
+		Help:    "How long computing a poll's results took, by poll type.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"vote_type"})
+
+	mongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "vote_mongo_command_duration_seconds",
+		Help:    "How long MongoDB commands took, by command.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"command"})
+
+	mongoErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "vote_mongo_command_errors_total",
+		Help: "MongoDB commands that failed, by command.",
+	}, []string{"command"})
+)
+
+// streamRoutes are left out of the request duration histogram
+var streamRoutes = map[string]bool{
+	"/stream/:topic": true,
+	"/ws/:topic":     true,
+}
+
+// Middleware counts every request and how long it took
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		if !streamRoutes[route] {
+			httpDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+		}
+	}
+}
+
+// Handler serves every metric. If token isn't empty, it must be sent as a
+// bearer token
+func Handler(token string) gin.HandlerFunc {
+	handler := promhttp.Handler()
+	return func(c *gin.Context) {
+		if token != "" {
+			given := []byte(c.GetHeader("Authorization"))
+			if subtle.ConstantTimeCompare(given, []byte("Bearer "+token)) != 1 {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// BallotCast counts a ballot cast in a poll of the given type
+func BallotCast(voteType string) {
+	ballotsCast.WithLabelValues(voteType).Inc()
+}
+
+// ObserveResult records how long a poll's results took to compute since start
+func ObserveResult(voteType string, start time.Time) {
+	resultDuration.WithLabelValues(voteType).Observe(time.Since(start).Seconds())
+}
+
+// CommandSucceeded records a successful MongoDB command, for use in an event.CommandMonitor
+func CommandSucceeded(ctx context.Context, e *event.CommandSucceededEvent) {
+	mongoDuration.WithLabelValues(e.CommandName).Observe(time.Duration(e.DurationNanos).Seconds())
+}
+
+// CommandFailed records a failed MongoDB command, for use in an event.CommandMonitor
+func CommandFailed(ctx context.Context, e *event.CommandFailedEvent) {
+	mongoDuration.WithLabelValues(e.CommandName).Observe(time.Duration(e.DurationNanos).Seconds())
+	mongoErrors.WithLabelValues(e.CommandName).Inc()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	minOptions                 = 2
+	maxOptions                 = 20
+	maxOptionLength            = 64
+	maxOptionDescriptionLength = 256
+	maxShortDescriptionLength  = 128
+)
+
+// optionPresets are the sets of options that can be picked instead of custom ones
+var optionPresets = map[string][]string{
+	"pass-fail":             {"Pass", "Fail", "Abstain"},
+	"pass-fail-conditional": {"Pass", "Fail/Conditional", "Abstain"},
+	"fail-conditional":      {"Fail", "Conditional", "Abstain"},
+}
+
+// These names are already used by fields on the ballot form, so an option
+// with the same name would be indistinguishable from them
+var reservedOptions = []string{"option", "writein", "writeinOption"}
+
+// OptionInput is a single row of the custom option editor, as the user typed it
+type OptionInput struct {
+	Name        string
+	Description string
+	Error       string
+}
+
+// parseOptionInputs reads the ordered custom option rows from a submitted form
+func parseOptionInputs(c *gin.Context) []OptionInput {
+	names := c.PostFormArray("customOption")
+	descriptions := c.PostFormArray("customOptionDescription")
+
+	inputs := make([]OptionInput, 0, len(names))
+	for i, name := range names {
+		input := OptionInput{Name: strings.TrimSpace(name)}
+		if i < len(descriptions) {
+			input.Description = strings.TrimSpace(descriptions[i])
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs
+}
+
+// validateOptions checks every row for problems, recording them on the row
+// itself, and adds a summary to errs. It returns true if the options are usable
+func validateOptions(inputs []OptionInput, errs map[string]string) bool {
+	valid := true
+	seen := make(map[string]bool)
+	for i := range inputs {
+		input := &inputs[i]
+		key := strings.ToLower(input.Name)
+		switch {
+		case input.Name == "":
+			input.Error = "Option can't be blank"
+		case utf8.RuneCountInString(input.Name) > maxOptionLength:
+			input.Error = fmt.Sprintf("Option can't be longer than %d characters", maxOptionLength)
+		case utf8.RuneCountInString(input.Description) > maxOptionDescriptionLength:
+			input.Error = fmt.Sprintf("Description can't be longer than %d characters", maxOptionDescriptionLength)
+		case seen[key]:
+			input.Error = "Option is a duplicate"
+		case containsStringFold(reservedOptions, input.Name):
+			input.Error = "\"" + input.Name + "\" is a reserved name"
+		}
+		seen[key] = true
+		if input.Error != "" {
+			valid = false
+		}
+	}
+
+	if !valid {
+		errs["options"] = "Some options need fixing"
+	} else if len(inputs) < minOptions {
+		errs["options"] = fmt.Sprintf("A poll needs at least %d options", minOptions)
+		valid = false
+	} else if len(inputs) > maxOptions {
+		errs["options"] = fmt.Sprintf("A poll can't have more than %d options", maxOptions)
+		valid = false
+	}
+	return valid
+}
+
+// applyOptions sets the poll's options and descriptions from validated inputs,
+// adding Abstain to simple polls that don't already have it
+func applyOptions(poll *database.Poll, inputs []OptionInput) {
+	poll.Options = make([]string, 0, len(inputs)+1)
+	poll.OptionDescriptions = nil
+	for _, input := range inputs {
+		poll.Options = append(poll.Options, input.Name)
+		if input.Description != "" {
+			poll.OptionDescriptions = append(poll.OptionDescriptions, database.OptionDescription{
+				Option:      input.Name,
+				Description: input.Description,
+			})
+		}
+	}
+	poll.Options = withAbstain(poll.VoteType, poll.Options)
+}
+
+// withAbstain adds Abstain to the options of a simple vote that doesn't already have it
+func withAbstain(voteType string, options []string) []string {
+	if voteType == database.POLL_TYPE_SIMPLE && !containsStringFold(options, "Abstain") {
+		return append(options, "Abstain")
+	}
+	return options
+}
+
+// validateDescription checks the poll's short description, adding any problem to errs
+func validateDescription(shortDescription string, errs map[string]string) {
+	if strings.TrimSpace(shortDescription) == "" {
+		errs["shortDescription"] = "A short description is required"
+	} else if utf8.RuneCountInString(shortDescription) > maxShortDescriptionLength {
+		errs["shortDescription"] = fmt.Sprintf("Short description can't be longer than %d characters", maxShortDescriptionLength)
+	}
+}
+
+func containsStringFold(arr []string, val string) bool {
+	for _, a := range arr {
+		if strings.EqualFold(a, val) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/computersciencehouse/vote/database"
+)
+
+// optionInputs builds a row for each name, without descriptions
+func optionInputs(names ...string) []OptionInput {
+	inputs := make([]OptionInput, 0, len(names))
+	for _, name := range names {
+		inputs = append(inputs, OptionInput{Name: name})
+	}
+	return inputs
+}
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		inputs    []OptionInput
+		valid     bool
+		rowErrors []string
+		summary   string
+	}{
+		{
+			name:      "usable options",
+			inputs:    optionInputs("Yes", "No"),
+			valid:     true,
+			rowErrors: []string{"", ""},
+		},
+		{
+			name:      "blank option",
+			inputs:    optionInputs("Yes", ""),
+			rowErrors: []string{"", "Option can't be blank"},
+			summary:   "Some options need fixing",
+		},
+		{
+			name:      "duplicate ignores case",
+			inputs:    optionInputs("Yes", "yes"),
+			rowErrors: []string{"", "Option is a duplicate"},
+			summary:   "Some options need fixing",
+		},
+		{
+			name:      "reserved name",
+			inputs:    optionInputs("Yes", "WriteIn"),
+			rowErrors: []string{"", "\"WriteIn\" is a reserved name"},
+			summary:   "Some options need fixing",
+		},
+		{
+			name:      "too long",
+			inputs:    optionInputs("Yes", strings.Repeat("a", maxOptionLength+1)),
+			rowErrors: []string{"", "Option can't be longer than 64 characters"},
+			summary:   "Some options need fixing",
+		},
+		{
+			name:      "length counts characters, not bytes",
+			inputs:    optionInputs("Yes", strings.Repeat("é", maxOptionLength)),
+			valid:     true,
+			rowErrors: []string{"", ""},
+		},
+		{
+			name:      "description too long",
+			inputs:    []OptionInput{{Name: "Yes", Description: strings.Repeat("a", maxOptionDescriptionLength+1)}, {Name: "No"}},
+			rowErrors: []string{"Description can't be longer than 256 characters", ""},
+			summary:   "Some options need fixing",
+		},
+		{
+			name:      "too few",
+			inputs:    optionInputs("Yes"),
+			rowErrors: []string{""},
+			summary:   "A poll needs at least 2 options",
+		},
+		{
+			name:      "too many",
+			inputs:    optionInputs(strings.Split("abcdefghijklmnopqrstu", "")...),
+			rowErrors: strings.Split(strings.Repeat(",", maxOptions), ","),
+			summary:   "A poll can't have more than 20 options",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := make(map[string]string)
+			if valid := validateOptions(test.inputs, errs); valid != test.valid {
+				t.Errorf("validateOptions() = %v, want %v", valid, test.valid)
+			}
+			var rowErrors []string
+			for _, input := range test.inputs {
+				rowErrors = append(rowErrors, input.Error)
+			}
+			if !reflect.DeepEqual(rowErrors, test.rowErrors) {
+				t.Errorf("row errors = %q, want %q", rowErrors, test.rowErrors)
+			}
+			if errs["options"] != test.summary {
+				t.Errorf("summary = %q, want %q", errs["options"], test.summary)
+			}
+		})
+	}
+}
+
+func TestApplyOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		voteType     string
+		inputs       []OptionInput
+		options      []string
+		descriptions []database.OptionDescription
+	}{
+		{
+			name:     "simple poll gets abstain",
+			voteType: database.POLL_TYPE_SIMPLE,
+			inputs:   optionInputs("Yes", "No"),
+			options:  []string{"Yes", "No", "Abstain"},
+		},
+		{
+			name:     "simple poll keeps its own abstain",
+			voteType: database.POLL_TYPE_SIMPLE,
+			inputs:   optionInputs("Yes", "abstain", "No"),
+			options:  []string{"Yes", "abstain", "No"},
+		},
+		{
+			name:     "ranked poll doesn't get abstain",
+			voteType: database.POLL_TYPE_RANKED,
+			inputs:   optionInputs("A", "B"),
+			options:  []string{"A", "B"},
+		},
+		{
+			name:     "only options with descriptions are described",
+			voteType: database.POLL_TYPE_RANKED,
+			inputs:   []OptionInput{{Name: "A", Description: "First"}, {Name: "B"}},
+			options:  []string{"A", "B"},
+			descriptions: []database.OptionDescription{
+				{Option: "A", Description: "First"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			poll := &database.Poll{
+				VoteType:           test.voteType,
+				OptionDescriptions: []database.OptionDescription{{Option: "Old", Description: "Replaced"}},
+			}
+			applyOptions(poll, test.inputs)
+			if !reflect.DeepEqual(poll.Options, test.options) {
+				t.Errorf("options = %q, want %q", poll.Options, test.options)
+			}
+			if !reflect.DeepEqual(poll.OptionDescriptions, test.descriptions) {
+				t.Errorf("descriptions = %v, want %v", poll.OptionDescriptions, test.descriptions)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/computersciencehouse/vote/sse"
+	"github.com/sirupsen/logrus"
+)
+
+const schedulerInterval = 30 * time.Second
+
+// runScheduler opens and closes polls when their scheduled times arrive, and
+// records outcomes missing from closed polls, skipping its checks while the
+// database is unavailable
+func runScheduler(ctx context.Context, broker *sse.Broker) {
+	for {
+		if database.Available() {
+			runDue(ctx, broker)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(schedulerInterval):
+		}
+	}
+}
+
+// runDue opens and closes the polls that are due, then fills in missing outcomes
+func runDue(ctx context.Context, broker *sse.Broker) {
+	now := time.Now()
+
+	opening, err := database.GetPollsDueToOpen(ctx, now)
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "scheduler", "method": "runScheduler"}).Error("error finding polls to open")
+	}
+	for _, poll := range opening {
+		if err := poll.Start(ctx); err != nil {
+			logging.Logger.WithFields(logrus.Fields{"error": err, "module": "scheduler", "method": "runScheduler", "poll": poll.Id}).Error("error opening poll")
+			continue
+		}
+		logAction(ctx, "poll.open", logrus.Fields{"poll": poll.Id, "user": "scheduler"})
+		notifyOpened(ctx, broker, poll)
+		notifyMeeting(ctx, broker, poll.MeetingId)
+	}
+
+	closing, err := database.GetPollsDueToClose(ctx, now)
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "scheduler", "method": "runScheduler"}).Error("error finding polls to close")
+	}
+	for _, poll := range closing {
+		if err := poll.Close(ctx); err != nil {
+			logging.Logger.WithFields(logrus.Fields{"error": err, "module": "scheduler", "method": "runScheduler", "poll": poll.Id}).Error("error closing poll")
+			continue
+		}
+		logAction(ctx, "poll.close", logrus.Fields{"poll": poll.Id, "user": "scheduler"})
+		notifyClosed(ctx, broker, poll)
+		notifyMeeting(ctx, broker, poll.MeetingId)
+	}
+
+	if err := database.RecordMissingOutcomes(ctx); err != nil {
+		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "scheduler", "method": "runScheduler"}).Error("error recording missing outcomes")
+	}
+}
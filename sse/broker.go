@@ -24,107 +24,584 @@ CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
 package sse
 
 import (
+	"context"
+	"fmt"
 	"io"
-	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/computersciencehouse/vote/tracing"
+	ginsse "github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const patience time.Duration = time.Second * 1
+// OverflowPolicy decides what happens to a client whose queue is full when a new event arrives
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest discards the oldest queued event to make room
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowCoalesce discards queued events with the same name as the new one,
+	// since results events are snapshots and only the latest one matters
+	OverflowCoalesce OverflowPolicy = "coalesce"
+	// OverflowDisconnect closes the client's stream, which the browser will reopen
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+// Config controls how the broker copes with slow clients and reconnections
+type Config struct {
+	// QueueSize is how many events can wait to be written to each client
+	QueueSize int
+	Overflow  OverflowPolicy
+	// HistorySize is how many recent events are kept for each topic, to
+	// replay to clients that reconnect
+	HistorySize int
+	// Heartbeat is how often an idle stream gets a comment, so proxies don't
+	// time it out
+	Heartbeat time.Duration
+	// PubSub carries published events between instances. Without one, events
+	// stay within this instance
+	PubSub PubSub
+}
+
+// DefaultConfig is used for any setting left unset
+var DefaultConfig = Config{
+	QueueSize:   16,
+	Overflow:    OverflowCoalesce,
+	HistorySize: 32,
+	Heartbeat:   15 * time.Second,
+}
+
+// ParseOverflowPolicy checks that a policy name is one the broker knows about
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(name); policy {
+	case OverflowDropOldest, OverflowCoalesce, OverflowDisconnect:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q", name)
+}
 
 type (
 	NotificationEvent struct {
+		// Id is assigned by the broker when the event is published, and
+		// increases with every event
+		Id uint64
+		// Topic is the stream the event is delivered on, usually a poll id
+		Topic     string
 		EventName string
-		Payload   interface{}
+		// Audience limits who the event is delivered to, see AudienceManagers
+		Audience string
+		Payload  interface{}
+		// traceContext carries the publisher's span to wherever the event is
+		// delivered, so delivering it is part of the same trace
+		traceContext propagation.MapCarrier
 	}
 
+	// SnapshotFunc returns the events that bring a new client on a topic up to
+	// date, leaving out anything the requesting user isn't allowed to see
+	SnapshotFunc func(c *gin.Context, topic string) []NotificationEvent
+
+	// AuthorizeFunc reports whether the requesting user may receive events
+	// limited to AudienceManagers on a topic
+	AuthorizeFunc func(c *gin.Context, topic string) bool
+
 	NotifierChan chan NotificationEvent
 
+	// Subscriber is a single client's queue of events for a topic
+	Subscriber struct {
+		Topic string
+		// Events are queued here until the client is ready for them
+		Events NotifierChan
+		// Closed is closed when the broker disconnects the client
+		Closed chan struct{}
+		// Resumed is true if every event since the client's last event id was
+		// replayed, so it doesn't need a snapshot
+		Resumed bool
+		// Privileged subscribers also receive events limited to AudienceManagers
+		Privileged bool
+
+		// final is the last event to send before disconnecting, if any. It's
+		// set before Closed is closed
+		final       *NotificationEvent
+		lastEventId uint64
+		// logger carries the fields of the request the subscriber belongs to
+		logger     *logrus.Entry
+		registered chan bool
+	}
+
+	// Stats counts how the broker has been keeping up with its clients
+	Stats struct {
+		Clients   int64
+		Delivered int64
+		// Dropped counts events that were discarded because a client's queue was full
+		Dropped int64
+		// SlowClients counts the times an event found a client's queue full
+		SlowClients  int64
+		Disconnected int64
+	}
+
 	Broker struct {
+		config Config
 
-		// Events are pushed to this channel by the main events-gathering routine
+		// Events from every instance arrive on this channel to be sent to clients.
+		// Use Publish so other instances hear about them too
 		Notifier NotifierChan
 
+		pubsub PubSub
+
 		// New client connections
-		newClients chan NotifierChan
+		newClients chan *Subscriber
 
 		// Closed client connections
-		closingClients chan NotifierChan
+		closingClients chan *Subscriber
+
+		// Client connections registry, by the topic they're subscribed to
+		topics map[string]map[*Subscriber]struct{}
+
+		// Closed once the broker has stopped listening
+		done chan struct{}
+		// 1 while Listen is running
+		listening int32
 
-		// Client connections registry
-		clients map[NotifierChan]struct{}
+		// Recent events for each topic, oldest first
+		history map[string][]NotificationEvent
+		lastId  uint64
+
+		// Snapshot is called when a client subscribes without being able to resume
+		Snapshot SnapshotFunc
+		// Authorize decides which subscribers are privileged. Without it, nobody is
+		Authorize AuthorizeFunc
+		// Identify names WebSocket clients for presence. Without it, presence is ignored
+		Identify IdentifyFunc
+
+		stats Stats
+		// topicClients counts the clients of each topic, so they can be read
+		// from outside the Listen goroutine
+		topicClients   map[string]int
+		topicClientsMu sync.Mutex
 	}
 )
 
-func NewBroker() (broker *Broker) {
+func NewBroker(config Config) (broker *Broker) {
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultConfig.QueueSize
+	}
+	if config.Overflow == "" {
+		config.Overflow = DefaultConfig.Overflow
+	}
+	if config.HistorySize <= 0 {
+		config.HistorySize = DefaultConfig.HistorySize
+	}
+	if config.Heartbeat <= 0 {
+		config.Heartbeat = DefaultConfig.Heartbeat
+	}
+	if config.PubSub == nil {
+		config.PubSub = NewMemoryPubSub()
+	}
+
 	// Instantiate a broker
 	return &Broker{
+		config:         config,
 		Notifier:       make(NotifierChan, 1),
-		newClients:     make(chan NotifierChan),
-		closingClients: make(chan NotifierChan),
-		clients:        make(map[NotifierChan]struct{}),
+		pubsub:         config.PubSub,
+		newClients:     make(chan *Subscriber),
+		closingClients: make(chan *Subscriber),
+		topics:         make(map[string]map[*Subscriber]struct{}),
+		done:           make(chan struct{}),
+		topicClients:   make(map[string]int),
+		history:        make(map[string][]NotificationEvent),
+	}
+}
+
+// Subscribe registers a new client for a topic. Any events after lastEventId
+// that are still in the topic's history are queued for it first; pass 0 for
+// a client that hasn't seen any events. The subscriber's events must be read
+// until it's passed to Unsubscribe or its Closed channel is closed. ctx only
+// provides the fields the subscriber's log entries carry
+func (broker *Broker) Subscribe(ctx context.Context, topic string, lastEventId uint64, privileged bool) *Subscriber {
+	subscriber := &Subscriber{
+		Topic:       topic,
+		Events:      make(NotifierChan, broker.config.QueueSize),
+		Closed:      make(chan struct{}),
+		Privileged:  privileged,
+		lastEventId: lastEventId,
+		logger:      logging.FromContext(ctx).WithFields(logrus.Fields{"module": "sse", "topic": topic}),
+		registered:  make(chan bool),
+	}
+	select {
+	case broker.newClients <- subscriber:
+		subscriber.Resumed = <-subscriber.registered
+	case <-broker.done:
+		// The server is shutting down, so the client is told to go elsewhere straight away
+		subscriber.disconnect(restartingEvent(topic))
+	}
+	return subscriber
+}
+
+// Publish sends an event to the subscribers of its topic on every instance
+func (broker *Broker) Publish(ctx context.Context, event NotificationEvent) {
+	ctx, span := tracing.Tracer.Start(ctx, "sse.Publish", trace.WithAttributes(eventAttributes(event)...))
+	defer span.End()
+
+	logger := logging.FromContext(ctx).WithFields(logrus.Fields{"module": "sse", "method": "Publish", "topic": event.Topic, "event": event.EventName})
+	select {
+	case <-broker.done:
+		logger.Warn("dropped event, since the broker has stopped")
+		span.SetStatus(codes.Error, "broker stopped")
+		return
+	default:
+	}
+
+	event.traceContext = make(propagation.MapCarrier)
+	otel.GetTextMapPropagator().Inject(ctx, event.traceContext)
+
+	// The event outlives the request that published it, so only the
+	// request's log fields and span are kept
+	ctx, cancel := context.WithTimeout(trace.ContextWithSpan(logging.NewContext(context.Background(), logger.Data), span), 10*time.Second)
+	defer cancel()
+
+	if err := broker.pubsub.Publish(ctx, event); err != nil {
+		tracing.RecordError(span, err)
+		logger.WithError(err).Error("error publishing event")
+	}
+}
+
+// eventAttributes describe an event on a span
+func eventAttributes(event NotificationEvent) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("sse.topic", event.Topic),
+		attribute.String("sse.event", event.EventName),
+	}
+}
+
+// Unsubscribe stops delivering events to a subscriber
+func (broker *Broker) Unsubscribe(subscriber *Subscriber) {
+	select {
+	case broker.closingClients <- subscriber:
+	case <-broker.done:
+	}
+}
+
+// TopicClients returns how many clients are subscribed to each topic
+func (broker *Broker) TopicClients() map[string]int {
+	broker.topicClientsMu.Lock()
+	defer broker.topicClientsMu.Unlock()
+
+	counts := make(map[string]int, len(broker.topicClients))
+	for topic, count := range broker.topicClients {
+		counts[topic] = count
+	}
+	return counts
+}
+
+// countClients records the number of clients subscribed to a topic
+func (broker *Broker) countClients(topic string, count int) {
+	broker.topicClientsMu.Lock()
+	defer broker.topicClientsMu.Unlock()
+
+	if count == 0 {
+		delete(broker.topicClients, topic)
+	} else {
+		broker.topicClients[topic] = count
+	}
+}
+
+// Running reports whether the broker is listening for events
+func (broker *Broker) Running() bool {
+	return atomic.LoadInt32(&broker.listening) == 1
+}
+
+// Stats returns a snapshot of the broker's counters
+func (broker *Broker) Stats() Stats {
+	return Stats{
+		Clients:      atomic.LoadInt64(&broker.stats.Clients),
+		Delivered:    atomic.LoadInt64(&broker.stats.Delivered),
+		Dropped:      atomic.LoadInt64(&broker.stats.Dropped),
+		SlowClients:  atomic.LoadInt64(&broker.stats.SlowClients),
+		Disconnected: atomic.LoadInt64(&broker.stats.Disconnected),
 	}
 }
 
 func (broker *Broker) ServeHTTP(c *gin.Context) {
-	eventName := c.Param("topic")
+	topic := c.Param("topic")
+	// Browsers send the id of the last event they saw when they reconnect
+	lastEventId, _ := strconv.ParseUint(c.GetHeader("Last-Event-ID"), 10, 64)
 
-	// Each connection registers its own message channel with the Broker's connections registry
-	messageChan := make(NotifierChan)
+	privileged := broker.Authorize != nil && broker.Authorize(c, topic)
 
-	// Signal the broker that we have a new connection
-	broker.newClients <- messageChan
+	// Each connection registers its own queue with the Broker's connections registry
+	subscriber := broker.Subscribe(c.Request.Context(), topic, lastEventId, privileged)
+	subscriber.logger.WithFields(logrus.Fields{"transport": "sse", "resumed": subscriber.Resumed}).Info("stream opened")
 
-	// Remove this client from the map of connected clients
-	// when this handler exits.
-	defer func() {
-		broker.closingClients <- messageChan
-	}()
+	// Remove this client from the registry when this handler exits.
+	defer broker.Unsubscribe(subscriber)
+	defer subscriber.logger.WithField("transport", "sse").Info("stream closed")
 
-	c.Stream(func(w io.Writer) bool {
-		// Emit Server Sent Events compatible
-		event := <-messageChan
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
 
-		switch eventName {
-		case event.EventName:
-			c.SSEvent(event.EventName, event.Payload)
+	// A client that can't pick up where it left off is sent the current state instead.
+	// These events were never published, so they have no id
+	if !subscriber.Resumed && broker.Snapshot != nil {
+		for _, event := range broker.Snapshot(c, topic) {
+			writeEvent(c.Writer, event)
 		}
+	}
+	c.Writer.Flush()
 
+	heartbeat := time.NewTicker(broker.config.Heartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		// Emit Server Sent Events compatible
+		select {
+		case event := <-subscriber.Events:
+			writeEvent(w, event)
+		case <-heartbeat.C:
+			io.WriteString(w, ": heartbeat\n\n")
+		case <-subscriber.Closed:
+			if subscriber.final != nil {
+				writeEvent(w, *subscriber.final)
+				c.Writer.Flush()
+			}
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
 		// Flush the data immediately instead of buffering it for later.
 		c.Writer.Flush()
-
 		return true
 	})
 }
 
-// Listen for new notifications and redistribute them to clients
-func (broker *Broker) Listen() {
+func writeEvent(w io.Writer, event NotificationEvent) {
+	id := ""
+	if event.Id != 0 {
+		id = strconv.FormatUint(event.Id, 10)
+	}
+	ginsse.Encode(w, ginsse.Event{
+		Id:    id,
+		Event: event.EventName,
+		Data:  event.Payload,
+	})
+}
+
+// Listen for new notifications and redistribute them to clients, until ctx
+// is done. Then every client is sent EventServerRestarting and disconnected
+func (broker *Broker) Listen(ctx context.Context) {
+	atomic.StoreInt32(&broker.listening, 1)
+	go func() {
+		if err := broker.pubsub.Subscribe(ctx, broker.Notifier); err != nil && ctx.Err() == nil {
+			logging.Logger.WithFields(logrus.Fields{"error": err, "module": "sse", "method": "Listen"}).Error("stopped receiving events")
+		}
+	}()
+
 	for {
 		select {
+		case <-ctx.Done():
+			broker.shutdown()
+			return
 		case s := <-broker.newClients:
 
 			// A new client has connected.
-			// Register their message channel
-			broker.clients[s] = struct{}{}
-			log.Printf("Client added. %d registered clients", len(broker.clients))
+			// Register their queue under its topic
+			clients, ok := broker.topics[s.Topic]
+			if !ok {
+				clients = make(map[*Subscriber]struct{})
+				broker.topics[s.Topic] = clients
+			}
+			clients[s] = struct{}{}
+			atomic.AddInt64(&broker.stats.Clients, 1)
+			broker.countClients(s.Topic, len(clients))
+			s.logger.WithField("clients", len(clients)).Debug("client added")
+			s.registered <- broker.replay(s)
 		case s := <-broker.closingClients:
 
 			// A client has dettached and we want to
 			// stop sending them messages.
-			delete(broker.clients, s)
-			log.Printf("Removed client. %d registered clients", len(broker.clients))
+			broker.remove(s)
 		case event := <-broker.Notifier:
 
 			// We got a new event from the outside!
-			// Send event to all connected clients
-			for clientMessageChan := range broker.clients {
-				select {
-				case clientMessageChan <- event:
-				case <-time.After(patience):
-					log.Print("Skipping client.")
+			event = broker.remember(event)
+
+			// Queue it for the clients subscribed to its topic, without ever
+			// waiting on one of them
+			broker.deliverAll(event)
+		}
+	}
+}
+
+// remember numbers an event, unless the pub/sub already has, and keeps it in
+// its topic's history for clients that reconnect later
+func (broker *Broker) remember(event NotificationEvent) NotificationEvent {
+	if event.Id == 0 {
+		event.Id = broker.lastId + 1
+	}
+	if event.Id > broker.lastId {
+		broker.lastId = event.Id
+	}
+	history := append(broker.history[event.Topic], event)
+	if len(history) > broker.config.HistorySize {
+		history = history[len(history)-broker.config.HistorySize:]
+	}
+	broker.history[event.Topic] = history
+	return event
+}
+
+// deliverAll queues an event for every client subscribed to its topic, in a
+// span that's part of the trace that published it
+func (broker *Broker) deliverAll(event NotificationEvent) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), event.traceContext)
+	_, span := tracing.Tracer.Start(ctx, "sse.deliver", trace.WithAttributes(eventAttributes(event)...))
+	defer span.End()
+
+	clients := broker.topics[event.Topic]
+	dropped := atomic.LoadInt64(&broker.stats.Dropped)
+	for s := range clients {
+		broker.deliver(s, event)
+	}
+	span.SetAttributes(
+		attribute.Int64("sse.event_id", int64(event.Id)),
+		attribute.Int("sse.clients", len(clients)),
+		attribute.Int64("sse.dropped", atomic.LoadInt64(&broker.stats.Dropped)-dropped),
+	)
+}
+
+// shutdown disconnects every client with a final event telling it the server
+// is restarting, so it reconnects, hopefully to another instance
+func (broker *Broker) shutdown() {
+	atomic.StoreInt32(&broker.listening, 0)
+	close(broker.done)
+	for topic, clients := range broker.topics {
+		for s := range clients {
+			s.disconnect(restartingEvent(topic))
+		}
+	}
+	broker.topics = make(map[string]map[*Subscriber]struct{})
+	atomic.StoreInt64(&broker.stats.Clients, 0)
+	broker.topicClientsMu.Lock()
+	broker.topicClients = make(map[string]int)
+	broker.topicClientsMu.Unlock()
+	logging.Logger.WithFields(logrus.Fields{"module": "sse", "method": "shutdown"}).Info("broker stopped, disconnected all clients")
+}
+
+func restartingEvent(topic string) *NotificationEvent {
+	event := NewPollEvent(topic, EventServerRestarting, AudienceEveryone, nil)
+	return &event
+}
+
+// disconnect closes a subscriber, sending it final first if that isn't nil
+func (s *Subscriber) disconnect(final *NotificationEvent) {
+	s.final = final
+	close(s.Closed)
+}
+
+// replay queues the events a subscriber missed since its last event id. It
+// returns false without queueing anything if some of them are no longer in
+// the history, or the subscriber has never seen an event
+func (broker *Broker) replay(s *Subscriber) bool {
+	if s.lastEventId == 0 || s.lastEventId > broker.lastId {
+		return false
+	}
+
+	history := broker.history[s.Topic]
+	// Ids are shared between topics, so a full history that starts after the
+	// client's last event may have lost some of the events it missed
+	if len(history) == broker.config.HistorySize && history[0].Id > s.lastEventId {
+		return false
+	}
+	for _, event := range history {
+		if event.Id > s.lastEventId {
+			broker.deliver(s, event)
+		}
+	}
+	return true
+}
+
+// remove takes a subscriber out of the registry, if it's still there
+func (broker *Broker) remove(s *Subscriber) {
+	clients := broker.topics[s.Topic]
+	if _, ok := clients[s]; !ok {
+		return
+	}
+	delete(clients, s)
+	if len(clients) == 0 {
+		delete(broker.topics, s.Topic)
+	}
+	atomic.AddInt64(&broker.stats.Clients, -1)
+	broker.countClients(s.Topic, len(clients))
+	s.logger.WithField("clients", len(clients)).Debug("client removed")
+}
+
+// deliver queues an event for a subscriber, applying the overflow policy if
+// its queue is full
+func (broker *Broker) deliver(s *Subscriber, event NotificationEvent) {
+	if event.Audience == AudienceManagers && !s.Privileged {
+		return
+	}
+
+	select {
+	case s.Events <- event:
+		atomic.AddInt64(&broker.stats.Delivered, 1)
+		return
+	default:
+	}
+
+	atomic.AddInt64(&broker.stats.SlowClients, 1)
+	switch broker.config.Overflow {
+	case OverflowDisconnect:
+		broker.remove(s)
+		s.disconnect(nil)
+		atomic.AddInt64(&broker.stats.Disconnected, 1)
+		s.logger.Warn("disconnected slow client")
+		return
+	case OverflowCoalesce:
+		// Only this goroutine adds to the queue, so whatever is taken out
+		// can be put back without the queue filling up again
+		kept := make([]NotificationEvent, 0, cap(s.Events))
+	drain:
+		for {
+			select {
+			case queued := <-s.Events:
+				if queued.EventName == event.EventName {
+					atomic.AddInt64(&broker.stats.Dropped, 1)
+				} else {
+					kept = append(kept, queued)
 				}
+			default:
+				break drain
 			}
 		}
+		if len(kept) == cap(s.Events) {
+			kept = kept[1:]
+			atomic.AddInt64(&broker.stats.Dropped, 1)
+		}
+		for _, queued := range kept {
+			s.Events <- queued
+		}
+	case OverflowDropOldest:
+		select {
+		case <-s.Events:
+			atomic.AddInt64(&broker.stats.Dropped, 1)
+		default:
+		}
+	}
+
+	select {
+	case s.Events <- event:
+		atomic.AddInt64(&broker.stats.Delivered, 1)
+	default:
+		atomic.AddInt64(&broker.stats.Dropped, 1)
 	}
 }
@@ -0,0 +1,324 @@
+package sse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// listen runs a broker until the returned function stops it, which also
+// disconnects its clients
+func listen(broker *Broker) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		broker.Listen(ctx)
+		close(stopped)
+	}()
+	return func() {
+		cancel()
+		<-stopped
+	}
+}
+
+// benchmarkFanOut publishes events to a single subscriber while idle clients
+// are subscribed to other topics, which shouldn't make publishing any slower
+func benchmarkFanOut(b *testing.B, idle int) {
+	logging.Logger.SetOutput(io.Discard)
+	defer logging.Logger.SetOutput(os.Stdout)
+
+	broker := NewBroker(DefaultConfig)
+	stop := listen(broker)
+	defer stop()
+
+	for i := 0; i < idle; i++ {
+		broker.Subscribe(context.Background(), fmt.Sprintf("idle-%d", i), 0, false)
+	}
+	subscriber := broker.Subscribe(context.Background(), "poll", 0, false)
+
+	event := NotificationEvent{Topic: "poll", EventName: "poll", Payload: "{}"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		broker.Notifier <- event
+		<-subscriber.Events
+	}
+}
+
+func BenchmarkFanOutIdle0(b *testing.B)     { benchmarkFanOut(b, 0) }
+func BenchmarkFanOutIdle1000(b *testing.B)  { benchmarkFanOut(b, 1000) }
+func BenchmarkFanOutIdle10000(b *testing.B) { benchmarkFanOut(b, 10000) }
+
+// benchmarkSameTopic publishes events that every subscriber receives
+func benchmarkSameTopic(b *testing.B, subscribers int) {
+	logging.Logger.SetOutput(io.Discard)
+	defer logging.Logger.SetOutput(os.Stdout)
+
+	broker := NewBroker(DefaultConfig)
+	stop := listen(broker)
+	defer stop()
+
+	var received sync.WaitGroup
+	for i := 0; i < subscribers; i++ {
+		subscriber := broker.Subscribe(context.Background(), "poll", 0, false)
+		go func() {
+			for {
+				select {
+				case <-subscriber.Events:
+					received.Done()
+				case <-subscriber.Closed:
+					return
+				}
+			}
+		}()
+	}
+
+	event := NotificationEvent{Topic: "poll", EventName: "poll", Payload: "{}"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		received.Add(subscribers)
+		broker.Notifier <- event
+		received.Wait()
+	}
+}
+
+func BenchmarkSameTopic100(b *testing.B)  { benchmarkSameTopic(b, 100) }
+func BenchmarkSameTopic1000(b *testing.B) { benchmarkSameTopic(b, 1000) }
+
+// queuedSubscriber registers a client on the "poll" topic, as Listen would,
+// with a queue already holding events with the given names
+func queuedSubscriber(broker *Broker, names ...string) *Subscriber {
+	s := &Subscriber{
+		Topic:  "poll",
+		Events: make(NotifierChan, broker.config.QueueSize),
+		Closed: make(chan struct{}),
+		logger: logrus.NewEntry(logging.Logger),
+	}
+	broker.topics[s.Topic] = map[*Subscriber]struct{}{s: {}}
+	atomic.AddInt64(&broker.stats.Clients, 1)
+	for i, name := range names {
+		broker.deliver(s, NotificationEvent{Id: uint64(i + 1), Topic: "poll", EventName: name})
+	}
+	return s
+}
+
+// queued empties a subscriber's queue, returning the ids of what was in it
+func queued(s *Subscriber) []uint64 {
+	var ids []uint64
+	for {
+		select {
+		case event := <-s.Events:
+			ids = append(ids, event.Id)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestDeliverOverflow(t *testing.T) {
+	logging.Logger.SetOutput(io.Discard)
+	defer logging.Logger.SetOutput(os.Stdout)
+
+	tests := []struct {
+		name     string
+		policy   OverflowPolicy
+		queued   []string
+		event    string
+		want     []uint64
+		stats    Stats
+		isClosed bool
+	}{
+		{
+			name:   "coalesce drops queued events with the same name",
+			policy: OverflowCoalesce,
+			queued: []string{EventResultsUpdated, EventPollEdited, EventResultsUpdated},
+			event:  EventResultsUpdated,
+			want:   []uint64{2, 4},
+			stats:  Stats{Clients: 1, Delivered: 4, Dropped: 2, SlowClients: 1},
+		},
+		{
+			name:   "coalesce drops the oldest event when none share a name",
+			policy: OverflowCoalesce,
+			queued: []string{EventPollEdited, EventBallotCast, EventVisibilityChanged},
+			event:  EventResultsUpdated,
+			want:   []uint64{2, 3, 4},
+			stats:  Stats{Clients: 1, Delivered: 4, Dropped: 1, SlowClients: 1},
+		},
+		{
+			name:   "drop oldest",
+			policy: OverflowDropOldest,
+			queued: []string{EventResultsUpdated, EventResultsUpdated, EventResultsUpdated},
+			event:  EventResultsUpdated,
+			want:   []uint64{2, 3, 4},
+			stats:  Stats{Clients: 1, Delivered: 4, Dropped: 1, SlowClients: 1},
+		},
+		{
+			name:     "disconnect",
+			policy:   OverflowDisconnect,
+			queued:   []string{EventResultsUpdated, EventResultsUpdated, EventResultsUpdated},
+			event:    EventResultsUpdated,
+			want:     []uint64{1, 2, 3},
+			stats:    Stats{Delivered: 3, SlowClients: 1, Disconnected: 1},
+			isClosed: true,
+		},
+		{
+			name:   "room in the queue",
+			policy: OverflowDisconnect,
+			queued: []string{EventResultsUpdated, EventResultsUpdated},
+			event:  EventResultsUpdated,
+			want:   []uint64{1, 2, 3},
+			stats:  Stats{Clients: 1, Delivered: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := NewBroker(Config{QueueSize: 3, Overflow: tt.policy})
+			s := queuedSubscriber(broker, tt.queued...)
+
+			broker.deliver(s, NotificationEvent{Id: uint64(len(tt.queued) + 1), Topic: "poll", EventName: tt.event})
+
+			if got := queued(s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queued events = %v, want %v", got, tt.want)
+			}
+			if got := broker.Stats(); got != tt.stats {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.stats)
+			}
+			select {
+			case <-s.Closed:
+				if !tt.isClosed {
+					t.Error("subscriber was disconnected")
+				}
+				if _, ok := broker.topics["poll"][s]; ok {
+					t.Error("disconnected subscriber is still registered")
+				}
+			default:
+				if tt.isClosed {
+					t.Error("subscriber wasn't disconnected")
+				}
+			}
+		})
+	}
+}
+
+func TestDeliverAudience(t *testing.T) {
+	broker := NewBroker(Config{QueueSize: 3})
+	s := queuedSubscriber(broker)
+
+	broker.deliver(s, NotificationEvent{Id: 1, Topic: "poll", EventName: EventResultsUpdated, Audience: AudienceManagers})
+	broker.deliver(s, NotificationEvent{Id: 2, Topic: "poll", EventName: EventResultsUpdated, Audience: AudienceEveryone})
+
+	if got := queued(s); !reflect.DeepEqual(got, []uint64{2}) {
+		t.Errorf("queued events = %v, want [2]", got)
+	}
+	if got := broker.Stats(); got != (Stats{Clients: 1, Delivered: 1}) {
+		t.Errorf("Stats() = %+v, want %+v", got, Stats{Clients: 1, Delivered: 1})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name        string
+		published   []string
+		lastEventId uint64
+		wantResumed bool
+		want        []uint64
+	}{
+		{
+			name:        "missed events still in history",
+			published:   []string{"poll", "poll", "poll"},
+			lastEventId: 1,
+			wantResumed: true,
+			want:        []uint64{2, 3},
+		},
+		{
+			name:        "nothing missed",
+			published:   []string{"poll", "poll"},
+			lastEventId: 2,
+			wantResumed: true,
+		},
+		{
+			name:        "missed events older than history",
+			published:   []string{"poll", "poll", "poll", "poll", "poll"},
+			lastEventId: 1,
+		},
+		{
+			name:        "last event is the oldest in history",
+			published:   []string{"poll", "poll", "poll", "poll"},
+			lastEventId: 2,
+			wantResumed: true,
+			want:        []uint64{3, 4},
+		},
+		{
+			name:        "events from other topics left out",
+			published:   []string{"poll", "other", "poll", "other", "other", "other"},
+			lastEventId: 1,
+			wantResumed: true,
+			want:        []uint64{3},
+		},
+		{
+			name:      "never seen an event",
+			published: []string{"poll"},
+		},
+		{
+			name:        "last event id from before a restart",
+			published:   []string{"poll"},
+			lastEventId: 7,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := NewBroker(Config{QueueSize: 16, HistorySize: 3})
+			for _, topic := range tt.published {
+				broker.remember(NotificationEvent{Topic: topic, EventName: EventResultsUpdated})
+			}
+			s := queuedSubscriber(broker)
+			s.lastEventId = tt.lastEventId
+
+			if got := broker.replay(s); got != tt.wantResumed {
+				t.Errorf("replay() = %v, want %v", got, tt.wantResumed)
+			}
+			if got := queued(s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replayed events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribeResumes(t *testing.T) {
+	logging.Logger.SetOutput(io.Discard)
+	defer logging.Logger.SetOutput(os.Stdout)
+
+	broker := NewBroker(Config{QueueSize: 16, HistorySize: 3})
+	stop := listen(broker)
+	defer stop()
+
+	// Events are only published once a client is watching, so it's known when
+	// they've been recorded
+	watcher := broker.Subscribe(context.Background(), "poll", 0, false)
+	for i := 0; i < 3; i++ {
+		broker.Notifier <- NotificationEvent{Topic: "poll", EventName: EventResultsUpdated}
+		<-watcher.Events
+	}
+
+	resumed := broker.Subscribe(context.Background(), "poll", 1, false)
+	if !resumed.Resumed {
+		t.Error("subscriber with a recent last event id wasn't resumed")
+	}
+	if got := queued(resumed); !reflect.DeepEqual(got, []uint64{2, 3}) {
+		t.Errorf("replayed events = %v, want [2 3]", got)
+	}
+
+	fresh := broker.Subscribe(context.Background(), "poll", 0, false)
+	if fresh.Resumed {
+		t.Error("subscriber without a last event id was resumed")
+	}
+}
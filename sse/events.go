@@ -0,0 +1,130 @@
+package sse
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// SchemaVersion is bumped whenever the shape of an event's data changes in a
+// way existing pages wouldn't understand
+const SchemaVersion = 1
+
+// The types of event published about a poll. Each is sent as the SSE event
+// name, with an Envelope as its data
+const (
+	// EventBallotCast carries BallotCastData
+	EventBallotCast = "ballot-cast"
+	// EventResultsUpdated carries ResultsData
+	EventResultsUpdated = "results-updated"
+	// EventPollOpened carries no data
+	EventPollOpened = "poll-opened"
+	// EventPollClosed carries PollClosedData
+	EventPollClosed = "poll-closed"
+	// EventVisibilityChanged carries VisibilityData
+	EventVisibilityChanged = "visibility-changed"
+	// EventPollEdited carries no data
+	EventPollEdited = "poll-edited"
+	// EventServerRestarting carries no data. It's the last event a client
+	// receives before the server shuts down
+	EventServerRestarting = "server-restarting"
+	// EventPresence carries PresenceData, and is only published for WebSocket clients
+	EventPresence = "presence"
+)
+
+// The types of event published about a meeting, on a topic named by the
+// meeting's id. Each is sent as the SSE event name, with an Envelope as its data
+const (
+	// EventAgendaChanged carries no data. It's published when a poll is added
+	// to the meeting's agenda, or one of its polls opens or closes
+	EventAgendaChanged = "agenda"
+	// EventAttendanceChanged carries no data. It's published when someone
+	// checks in, or the chair marks someone present or absent
+	EventAttendanceChanged = "attendance"
+)
+
+// Presence statuses. A WebSocket client sends PresenceJoin when it connects,
+// and every client that sees a join answers with PresenceHere, so the newcomer
+// learns who was already watching. PresenceLeave is sent when a client disconnects
+const (
+	PresenceJoin  = "join"
+	PresenceHere  = "here"
+	PresenceLeave = "leave"
+)
+
+// Audiences an event can be limited to
+const (
+	// AudienceEveryone is the default, and includes every subscriber
+	AudienceEveryone = ""
+	// AudienceManagers only includes subscribers allowed to see hidden results
+	AudienceManagers = "managers"
+)
+
+// Envelope wraps the data of every event. Poll events fill in PollId, and
+// meeting events fill in MeetingId
+type Envelope struct {
+	Version   int         `json:"v"`
+	Type      string      `json:"type"`
+	PollId    string      `json:"pollId,omitempty"`
+	MeetingId string      `json:"meetingId,omitempty"`
+	Time      time.Time   `json:"time"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+type BallotCastData struct {
+	Turnout int64 `json:"turnout"`
+}
+
+// ResultsData holds a poll's current results. Multi-question ballots fill in
+// Questions, by question id, instead of Results
+type ResultsData struct {
+	Results   map[string]int            `json:"results,omitempty"`
+	Questions map[string]map[string]int `json:"questions,omitempty"`
+}
+
+type PollClosedData struct {
+	// Outcome is left out for hidden polls
+	Outcome string `json:"outcome,omitempty"`
+}
+
+type VisibilityData struct {
+	Hidden bool `json:"hidden"`
+}
+
+type PresenceData struct {
+	// User is left out of joins, which everyone receives
+	User   string `json:"user,omitempty"`
+	Status string `json:"status"`
+}
+
+// NewPollEvent builds an event of the given type for a poll's stream
+func NewPollEvent(pollId, eventType, audience string, data interface{}) NotificationEvent {
+	payload, _ := json.Marshal(Envelope{
+		Version: SchemaVersion,
+		Type:    eventType,
+		PollId:  pollId,
+		Time:    time.Now(),
+		Data:    data,
+	})
+	return NotificationEvent{
+		Topic:     pollId,
+		EventName: eventType,
+		Audience:  audience,
+		Payload:   string(payload),
+	}
+}
+
+// NewMeetingEvent builds an event of the given type for a meeting's stream
+func NewMeetingEvent(meetingId, eventType string, data interface{}) NotificationEvent {
+	payload, _ := json.Marshal(Envelope{
+		Version:   SchemaVersion,
+		Type:      eventType,
+		MeetingId: meetingId,
+		Time:      time.Now(),
+		Data:      data,
+	})
+	return NotificationEvent{
+		Topic:     meetingId,
+		EventName: eventType,
+		Payload:   string(payload),
+	}
+}
@@ -0,0 +1,143 @@
+package sse
+
+import (
+	"context"
+	"time"
+
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Published events only need to last long enough for every instance to see them
+	mongoEventLifetime = time.Hour
+	mongoRetryDelay    = 5 * time.Second
+)
+
+// MongoPubSub carries events between instances through a MongoDB collection.
+// Every instance inserts the events it publishes, and watches the collection's
+// change stream for events inserted by any instance. Change streams need a
+// replica set, which can have a single node
+type MongoPubSub struct {
+	collection *mongo.Collection
+}
+
+type mongoEvent struct {
+	Topic     string      `bson:"topic"`
+	EventName string      `bson:"eventName"`
+	Audience  string      `bson:"audience,omitempty"`
+	Payload   interface{} `bson:"payload"`
+	CreatedAt time.Time   `bson:"createdAt"`
+	// Trace is the publisher's trace context
+	Trace map[string]string `bson:"trace,omitempty"`
+}
+
+type mongoChange struct {
+	ClusterTime  primitive.Timestamp `bson:"clusterTime"`
+	FullDocument mongoEvent          `bson:"fullDocument"`
+}
+
+// NewMongoPubSub uses a collection for events. Nothing is sent to the database
+// until Subscribe, so it can be set up before the database is reachable
+func NewMongoPubSub(collection *mongo.Collection) *MongoPubSub {
+	return &MongoPubSub{collection: collection}
+}
+
+// ensureIndex expires old events so the collection doesn't grow forever
+func (pubsub *MongoPubSub) ensureIndex(ctx context.Context) error {
+	_, err := pubsub.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "createdAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(mongoEventLifetime.Seconds())),
+	})
+	return err
+}
+
+func (pubsub *MongoPubSub) Publish(ctx context.Context, event NotificationEvent) error {
+	_, err := pubsub.collection.InsertOne(ctx, mongoEvent{
+		Topic:     event.Topic,
+		EventName: event.EventName,
+		Audience:  event.Audience,
+		Payload:   event.Payload,
+		CreatedAt: time.Now(),
+		Trace:     event.traceContext,
+	})
+	return err
+}
+
+// Subscribe watches for inserted events until ctx is done, picking up where it
+// left off if the change stream is interrupted, and retrying until the database
+// is reachable. Each event's id comes from the time the database recorded it,
+// so ids agree between instances
+func (pubsub *MongoPubSub) Subscribe(ctx context.Context, events chan<- NotificationEvent) error {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.D{{Key: "operationType", Value: "insert"}}}}}
+	var resumeToken bson.Raw
+	indexed := false
+
+	for {
+		opts := options.ChangeStream()
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+
+		if !indexed {
+			if err := pubsub.ensureIndex(ctx); err != nil {
+				if !retryWatch(ctx, err) {
+					return ctx.Err()
+				}
+				continue
+			}
+			indexed = true
+		}
+
+		stream, err := pubsub.collection.Watch(ctx, pipeline, opts)
+		if err == nil {
+			for stream.Next(ctx) {
+				var change mongoChange
+				if err := stream.Decode(&change); err != nil {
+					logging.Logger.WithFields(logrus.Fields{"error": err, "module": "sse", "method": "MongoPubSub.Subscribe"}).Warn("skipping undecodable event")
+					continue
+				}
+				resumeToken = stream.ResumeToken()
+
+				select {
+				case events <- NotificationEvent{
+					Id:           uint64(change.ClusterTime.T)<<32 | uint64(change.ClusterTime.I),
+					Topic:        change.FullDocument.Topic,
+					EventName:    change.FullDocument.EventName,
+					Audience:     change.FullDocument.Audience,
+					Payload:      change.FullDocument.Payload,
+					traceContext: change.FullDocument.Trace,
+				}:
+				case <-ctx.Done():
+					stream.Close(context.Background())
+					return ctx.Err()
+				}
+			}
+			err = stream.Err()
+			stream.Close(context.Background())
+		}
+
+		if !retryWatch(ctx, err) {
+			return ctx.Err()
+		}
+	}
+}
+
+// retryWatch waits before the next attempt at watching for events, returning false
+// if ctx is done first
+func retryWatch(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	logging.Logger.WithFields(logrus.Fields{"error": err, "module": "sse", "method": "MongoPubSub.Subscribe"}).Warn("event stream interrupted, retrying")
+	select {
+	case <-time.After(mongoRetryDelay):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,89 @@
+package sse
+
+import (
+	"sync"
+	"time"
+)
+
+// presenceInterval is the least time between presence updates with the same
+// status from one connection. Every watcher answers each join, so without a
+// limit a burst of joins would cost a publish per watcher per join
+const presenceInterval = 2 * time.Second
+
+// presenceLimiter coalesces a connection's presence updates. The first update
+// with a status is published straight away, and any more within the interval
+// are published once when it's up. Publishing happens outside the lock, since
+// it can block on the broker
+type presenceLimiter struct {
+	interval time.Duration
+	publish  func(status string)
+
+	mu      sync.Mutex
+	last    map[string]time.Time
+	pending map[string]*time.Timer
+	stopped bool
+	// publishing tracks publishes in progress, so stop can wait for them
+	publishing sync.WaitGroup
+}
+
+func newPresenceLimiter(interval time.Duration, publish func(status string)) *presenceLimiter {
+	return &presenceLimiter{
+		interval: interval,
+		publish:  publish,
+		last:     make(map[string]time.Time),
+		pending:  make(map[string]*time.Timer),
+	}
+}
+
+// update publishes a status, or schedules it if the same status was published
+// too recently and isn't already scheduled
+func (l *presenceLimiter) update(status string) {
+	l.mu.Lock()
+	if l.stopped || l.pending[status] != nil {
+		l.mu.Unlock()
+		return
+	}
+	if wait := l.interval - time.Since(l.last[status]); wait > 0 {
+		l.pending[status] = time.AfterFunc(wait, func() { l.flush(status) })
+		l.mu.Unlock()
+		return
+	}
+	l.last[status] = time.Now()
+	l.publishing.Add(1)
+	l.mu.Unlock()
+
+	defer l.publishing.Done()
+	l.publish(status)
+}
+
+// flush publishes a scheduled status
+func (l *presenceLimiter) flush(status string) {
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		return
+	}
+	delete(l.pending, status)
+	l.last[status] = time.Now()
+	l.publishing.Add(1)
+	l.mu.Unlock()
+
+	defer l.publishing.Done()
+	l.publish(status)
+}
+
+// stop drops any scheduled updates and waits for any being published,
+// reporting whether anything was published, in which case the connection
+// should announce that it's leaving
+func (l *presenceLimiter) stop() bool {
+	l.mu.Lock()
+	l.stopped = true
+	for _, timer := range l.pending {
+		timer.Stop()
+	}
+	published := len(l.last) > 0
+	l.mu.Unlock()
+
+	l.publishing.Wait()
+	return published
+}
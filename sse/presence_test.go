@@ -0,0 +1,107 @@
+package sse
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder collects the statuses a presenceLimiter publishes
+type recorder struct {
+	mu       sync.Mutex
+	statuses []string
+}
+
+func (r *recorder) publish(status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses = append(r.statuses, status)
+}
+
+func (r *recorder) published() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.statuses...)
+}
+
+func TestPresenceLimiterCoalesces(t *testing.T) {
+	r := &recorder{}
+	interval := 50 * time.Millisecond
+	limiter := newPresenceLimiter(interval, r.publish)
+
+	limiter.update(PresenceJoin)
+	for i := 0; i < 10; i++ {
+		limiter.update(PresenceHere)
+	}
+	if got, want := r.published(), []string{PresenceJoin, PresenceHere}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v straight away, want %v", got, want)
+	}
+
+	time.Sleep(2 * interval)
+	if got, want := r.published(), []string{PresenceJoin, PresenceHere, PresenceHere}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v after the interval, want %v", got, want)
+	}
+
+	if !limiter.stop() {
+		t.Error("stop() = false after publishing")
+	}
+}
+
+func TestPresenceLimiterStop(t *testing.T) {
+	r := &recorder{}
+	interval := 50 * time.Millisecond
+	limiter := newPresenceLimiter(interval, r.publish)
+
+	limiter.update(PresenceHere)
+	limiter.update(PresenceHere)
+	limiter.stop()
+	limiter.update(PresenceHere)
+
+	time.Sleep(2 * interval)
+	if got, want := r.published(), []string{PresenceHere}; !reflect.DeepEqual(got, want) {
+		t.Errorf("published %v, want %v", got, want)
+	}
+
+	if newPresenceLimiter(interval, r.publish).stop() {
+		t.Error("stop() = true without publishing")
+	}
+}
+
+func TestPresenceLimiterPublishesOutsideLock(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	limiter := newPresenceLimiter(50*time.Millisecond, func(status string) {
+		if status == PresenceJoin {
+			close(started)
+			<-release
+		}
+	})
+
+	go limiter.update(PresenceJoin)
+	<-started
+
+	// The join is stuck publishing, which mustn't hold up other updates
+	done := make(chan struct{})
+	go func() {
+		limiter.update(PresenceHere)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("update blocked while another status was being published")
+	}
+
+	stopped := make(chan bool)
+	go func() { stopped <- limiter.stop() }()
+	select {
+	case <-stopped:
+		t.Fatal("stop() returned before the join finished publishing")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if !<-stopped {
+		t.Error("stop() = false after publishing")
+	}
+}
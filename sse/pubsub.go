@@ -0,0 +1,47 @@
+package sse
+
+import "context"
+
+// PubSub carries events between every instance of the app, so a client
+// connected to one instance hears about changes made through another
+type PubSub interface {
+	// Publish sends an event to every instance, including this one
+	Publish(ctx context.Context, event NotificationEvent) error
+	// Subscribe delivers every event published by any instance to events,
+	// until ctx is done. Events may come with an Id already set, which
+	// must increase across every instance
+	Subscribe(ctx context.Context, events chan<- NotificationEvent) error
+}
+
+// MemoryPubSub only carries events within this instance, for when it's the only one
+type MemoryPubSub struct {
+	events chan NotificationEvent
+}
+
+func NewMemoryPubSub() *MemoryPubSub {
+	return &MemoryPubSub{events: make(chan NotificationEvent)}
+}
+
+func (pubsub *MemoryPubSub) Publish(ctx context.Context, event NotificationEvent) error {
+	select {
+	case pubsub.events <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (pubsub *MemoryPubSub) Subscribe(ctx context.Context, events chan<- NotificationEvent) error {
+	for {
+		select {
+		case event := <-pubsub.events:
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// writeWait is how long a single message may take to write
+	writeWait = 10 * time.Second
+	// maxMessageSize limits what a client can send, since it only sends small control messages
+	maxMessageSize = 512
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+type (
+	// IdentifyFunc returns the name of the requesting user, which is shown
+	// to managers watching a topic
+	IdentifyFunc func(c *gin.Context) string
+
+	// socketMessage is sent in both directions over a WebSocket. The server
+	// sends events with type "event", carrying the same id, name and data as
+	// the SSE stream, and answers "ping" with "pong". Clients send "ping" and
+	// "presence", with one of the presence statuses
+	socketMessage struct {
+		Type   string          `json:"type"`
+		Id     uint64          `json:"id,omitempty"`
+		Event  string          `json:"event,omitempty"`
+		Data   json.RawMessage `json:"data,omitempty"`
+		Status string          `json:"status,omitempty"`
+	}
+)
+
+// ServeWebSocket streams a topic's events over a WebSocket, for clients that
+// handle those better than EventSource. Clients resume by passing the id of
+// the last event they saw as the lastEventId query parameter
+func (broker *Broker) ServeWebSocket(c *gin.Context) {
+	topic := c.Param("topic")
+	lastEventId, _ := strconv.ParseUint(c.Query("lastEventId"), 10, 64)
+
+	privileged := broker.Authorize != nil && broker.Authorize(c, topic)
+	user := ""
+	if broker.Identify != nil {
+		user = broker.Identify(c)
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already responded with an error
+		logging.FromContext(c.Request.Context()).WithFields(logrus.Fields{"error": err, "module": "sse", "topic": topic}).Warn("error upgrading websocket")
+		return
+	}
+	defer conn.Close()
+
+	subscriber := broker.Subscribe(c.Request.Context(), topic, lastEventId, privileged)
+	subscriber.logger.WithFields(logrus.Fields{"transport": "websocket", "resumed": subscriber.Resumed}).Info("stream opened")
+	defer broker.Unsubscribe(subscriber)
+	defer subscriber.logger.WithField("transport", "websocket").Info("stream closed")
+
+	// Only this goroutine writes to the connection, so the reader hands its
+	// replies over here
+	replies := make(chan socketMessage, 1)
+	done := make(chan struct{})
+	go broker.readSocket(logging.NewContext(context.Background(), subscriber.logger.Data), conn, topic, user, replies, done)
+
+	if !subscriber.Resumed && broker.Snapshot != nil {
+		for _, event := range broker.Snapshot(c, topic) {
+			if !writeSocketEvent(conn, event) {
+				return
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(broker.config.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event := <-subscriber.Events:
+			if !writeSocketEvent(conn, event) {
+				return
+			}
+		case reply := <-replies:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if conn.WriteJSON(reply) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)) != nil {
+				return
+			}
+		case <-subscriber.Closed:
+			closing := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow")
+			if subscriber.final != nil {
+				writeSocketEvent(conn, *subscriber.final)
+				closing = websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting")
+			}
+			conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(writeWait))
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// readSocket handles messages from a client until its connection closes or
+// misses a heartbeat, then closes done
+func (broker *Broker) readSocket(ctx context.Context, conn *websocket.Conn, topic, user string, replies chan<- socketMessage, done chan<- struct{}) {
+	defer close(done)
+
+	// The client must answer a ping before the next one is due
+	timeout := 2 * broker.config.Heartbeat
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+
+	presence := newPresenceLimiter(presenceInterval, func(status string) {
+		if status == PresenceJoin {
+			broker.Publish(ctx, NewPollEvent(topic, EventPresence, AudienceEveryone, PresenceData{Status: PresenceJoin}))
+		} else {
+			broker.Publish(ctx, NewPollEvent(topic, EventPresence, AudienceManagers, PresenceData{User: user, Status: status}))
+		}
+	})
+	defer func() {
+		if presence.stop() {
+			broker.Publish(ctx, NewPollEvent(topic, EventPresence, AudienceManagers, PresenceData{User: user, Status: PresenceLeave}))
+		}
+	}()
+
+	for {
+		var message socketMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+
+		switch message.Type {
+		case "ping":
+			select {
+			case replies <- socketMessage{Type: "pong"}:
+			default:
+			}
+		case "presence":
+			if user == "" {
+				continue
+			}
+			// Clients can send these as often as they like, so they're limited
+			// before they reach the pub/sub
+			switch message.Status {
+			case PresenceJoin, PresenceHere:
+				presence.update(message.Status)
+			}
+		}
+	}
+}
+
+// writeSocketEvent sends an event to a WebSocket client, reporting whether it could
+func writeSocketEvent(conn *websocket.Conn, event NotificationEvent) bool {
+	var data json.RawMessage
+	if payload, ok := event.Payload.(string); ok && json.Valid([]byte(payload)) {
+		data = json.RawMessage(payload)
+	} else if encoded, err := json.Marshal(event.Payload); err == nil {
+		data = encoded
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteJSON(socketMessage{
+		Type:  "event",
+		Id:    event.Id,
+		Event: event.EventName,
+		Data:  data,
+	}) == nil
+}
@@ -0,0 +1,43 @@
+function addQuestion() {
+  let questions = document.getElementById("questions");
+  let question = questions.querySelector(".question").cloneNode(true);
+  for (let input of question.querySelectorAll("input[type=text], textarea")) {
+    input.value = "";
+    input.classList.remove("is-invalid");
+  }
+  for (let checkbox of question.querySelectorAll("input[type=checkbox]")) {
+    checkbox.checked = false;
+  }
+  for (let feedback of question.querySelectorAll(".invalid-feedback")) {
+    feedback.remove();
+  }
+  questions.appendChild(question);
+}
+
+function removeQuestion(button) {
+  let questions = document.getElementById("questions");
+  if (questions.querySelectorAll(".question").length > 1) {
+    button.closest(".question").remove();
+  }
+}
+
+function moveQuestion(button, direction) {
+  let question = button.closest(".question");
+  if (direction < 0 && question.previousElementSibling) {
+    question.parentNode.insertBefore(question, question.previousElementSibling);
+  } else if (direction > 0 && question.nextElementSibling) {
+    question.parentNode.insertBefore(question.nextElementSibling, question);
+  }
+}
+
+// Each question's fields are named after its position, so they're renumbered
+// just before the form is submitted
+function numberQuestions() {
+  let questions = document.querySelectorAll("#questions .question");
+  questions.forEach(function (question, i) {
+    for (let field of question.querySelectorAll("[data-field]")) {
+      field.name = "q" + i + "." + field.dataset.field;
+    }
+  });
+  document.getElementById("questionCount").value = questions.length;
+}
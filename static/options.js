@@ -0,0 +1,28 @@
+function addOption() {
+  let rows = document.getElementById("optionRows");
+  let row = rows.querySelector(".option-row").cloneNode(true);
+  for (let input of row.querySelectorAll("input")) {
+    input.value = "";
+    input.classList.remove("is-invalid");
+  }
+  for (let feedback of row.querySelectorAll(".invalid-feedback")) {
+    feedback.remove();
+  }
+  rows.appendChild(row);
+}
+
+function removeOption(button) {
+  let rows = document.getElementById("optionRows");
+  if (rows.querySelectorAll(".option-row").length > 1) {
+    button.closest(".option-row").remove();
+  }
+}
+
+function moveOption(button, direction) {
+  let row = button.closest(".option-row");
+  if (direction < 0 && row.previousElementSibling) {
+    row.parentNode.insertBefore(row, row.previousElementSibling);
+  } else if (direction > 0 && row.nextElementSibling) {
+    row.parentNode.insertBefore(row.nextElementSibling, row);
+  }
+}
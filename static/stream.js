@@ -0,0 +1,131 @@
+// The newest version of the event schema this page understands
+const STREAM_SCHEMA_VERSION = 1;
+
+// pollStream listens to a poll's events, calling the handler registered for
+// each event type with the event's data. An event from a newer schema means
+// the server has been updated, so the page is reloaded to pick that up
+function pollStream(pollId, handlers, options) {
+  return topicStream(pollId, handlers, options);
+}
+
+// topicStream listens to any topic's events. options.transport picks "sse",
+// "websocket", or "auto" (the default), which uses EventSource where the
+// browser has it. With options.presence, a WebSocket also tells the server
+// this page is watching, so managers get "presence" events naming who is
+function topicStream(topic, handlers, options) {
+  options = options || {};
+  let transport = options.transport || "auto";
+  if (transport == "auto") {
+    transport = window.EventSource ? "sse" : "websocket";
+  }
+
+  let stream = null;
+  let dispatch = function (type, envelope) {
+    if (envelope.v > STREAM_SCHEMA_VERSION) {
+      window.location.reload();
+      return;
+    }
+    let data = envelope.data || {};
+    // Whoever is already watching answers a newcomer, so it learns about them
+    if (type == "presence" && data.status == "join" && options.presence && transport == "websocket") {
+      stream.send({ type: "presence", status: "here" });
+    }
+    if (handlers[type]) {
+      handlers[type](data, envelope);
+    }
+  };
+
+  if (transport == "websocket") {
+    stream = socketStream(topic, dispatch, options.presence);
+    return stream;
+  }
+
+  let source = new EventSource("/stream/" + topic);
+  for (let type in handlers) {
+    source.addEventListener(type, function (event) {
+      dispatch(type, JSON.parse(event.data));
+    });
+  }
+  return source;
+}
+
+// socketStream receives a topic's events over a WebSocket, reconnecting with
+// the last event id it saw whenever the connection drops
+function socketStream(topic, dispatch, presence) {
+  let protocol = window.location.protocol == "https:" ? "wss:" : "ws:";
+  let delay = 1000;
+  let stream = {
+    lastEventId: 0,
+    socket: null,
+    closed: false,
+    send: function (message) {
+      if (this.socket && this.socket.readyState == WebSocket.OPEN) {
+        this.socket.send(JSON.stringify(message));
+      }
+    },
+    close: function () {
+      this.closed = true;
+      this.socket.close();
+    },
+  };
+
+  let connect = function () {
+    let url = protocol + "//" + window.location.host + "/ws/" + topic;
+    if (stream.lastEventId) {
+      url += "?lastEventId=" + stream.lastEventId;
+    }
+    let socket = new WebSocket(url);
+    stream.socket = socket;
+
+    socket.onopen = function () {
+      delay = 1000;
+      if (presence) {
+        stream.send({ type: "presence", status: "join" });
+      }
+    };
+    socket.onmessage = function (message) {
+      let frame = JSON.parse(message.data);
+      if (frame.type != "event") {
+        return;
+      }
+      if (frame.id) {
+        stream.lastEventId = frame.id;
+      }
+      dispatch(frame.event, frame.data || {});
+    };
+    socket.onclose = function () {
+      if (!stream.closed) {
+        setTimeout(connect, delay);
+        delay = Math.min(delay * 2, 30000);
+      }
+    };
+  };
+  connect();
+  return stream;
+}
+
+// updateResults sets the count shown for each option, adding rows for options
+// that aren't shown yet, such as new write-ins
+function updateResults(container, prefix, results) {
+  for (let option in results) {
+    let element = document.getElementById(prefix + option);
+    if (element == null) {
+      element = document.createElement("div");
+      element.id = prefix + option;
+      element.style = "font-size: 1.25rem; line-height: 1.25";
+      container.appendChild(element);
+      container.appendChild(document.createElement("br"));
+    }
+    element.innerText = option + ": " + results[option];
+  }
+}
+
+// streamTransport reads the transport a page was asked to use from its
+// ?transport= query parameter, so a display can be pointed at WebSockets
+function streamTransport() {
+  return new URLSearchParams(window.location.search).get("transport") || "auto";
+}
+
+function reloadPage() {
+  window.location.reload();
+}
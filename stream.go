@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+
+	csh_auth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// resultsEvent builds the event carrying a poll's current results, which only
+// managers receive while the poll is hidden
+func resultsEvent(ctx context.Context, poll *database.Poll) (sse.NotificationEvent, error) {
+	var data sse.ResultsData
+	var err error
+	if poll.VoteType == database.POLL_TYPE_MULTI {
+		data.Questions, err = poll.GetQuestionResults(ctx)
+	} else {
+		data.Results, err = poll.GetResult(ctx)
+	}
+	if err != nil {
+		return sse.NotificationEvent{}, err
+	}
+
+	audience := sse.AudienceEveryone
+	if poll.Hidden {
+		audience = sse.AudienceManagers
+	}
+	return sse.NewPollEvent(poll.Id, sse.EventResultsUpdated, audience, data), nil
+}
+
+// notifyResults publishes a poll's current results
+func notifyResults(ctx context.Context, broker *sse.Broker, poll *database.Poll) {
+	if event, err := resultsEvent(ctx, poll); err == nil {
+		broker.Publish(ctx, event)
+	}
+}
+
+// notifyBallotCast publishes a new ballot in a poll, followed by the updated results
+func notifyBallotCast(ctx context.Context, broker *sse.Broker, poll *database.Poll) {
+	if turnout, err := database.CountVotes(ctx, poll.Id); err == nil {
+		broker.Publish(ctx, sse.NewPollEvent(poll.Id, sse.EventBallotCast, sse.AudienceEveryone, sse.BallotCastData{Turnout: turnout}))
+	}
+	notifyResults(ctx, broker, poll)
+}
+
+func notifyOpened(ctx context.Context, broker *sse.Broker, poll *database.Poll) {
+	broker.Publish(ctx, sse.NewPollEvent(poll.Id, sse.EventPollOpened, sse.AudienceEveryone, nil))
+}
+
+func notifyClosed(ctx context.Context, broker *sse.Broker, poll *database.Poll) {
+	data := sse.PollClosedData{}
+	if !poll.Hidden {
+		data.Outcome = poll.Outcome
+	}
+	broker.Publish(ctx, sse.NewPollEvent(poll.Id, sse.EventPollClosed, sse.AudienceEveryone, data))
+}
+
+// notifyVisibility publishes a poll being hidden or revealed. Revealed
+// results are published to everyone, since most subscribers haven't seen them
+func notifyVisibility(ctx context.Context, broker *sse.Broker, poll *database.Poll) {
+	broker.Publish(ctx, sse.NewPollEvent(poll.Id, sse.EventVisibilityChanged, sse.AudienceEveryone, sse.VisibilityData{Hidden: poll.Hidden}))
+	if !poll.Hidden {
+		notifyResults(ctx, broker, poll)
+	}
+}
+
+func notifyEdited(ctx context.Context, broker *sse.Broker, poll *database.Poll) {
+	broker.Publish(ctx, sse.NewPollEvent(poll.Id, sse.EventPollEdited, sse.AudienceEveryone, nil))
+}
+
+// streamAuthorize lets a poll's managers receive its results while it's hidden
+func streamAuthorize(c *gin.Context, topic string) bool {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+
+	poll, err := database.GetPoll(c, topic)
+	if err != nil {
+		return false
+	}
+	return poll.CanManage(claims.UserInfo.Username, claims.UserInfo.Groups)
+}
+
+// streamIdentify names the user behind a WebSocket for presence
+func streamIdentify(c *gin.Context) string {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+	return claims.UserInfo.Username
+}
+
+// streamSnapshot sends a new subscriber to a poll's stream the poll's current
+// results, as long as they're allowed to see them. Other topics, like
+// meetings, have nothing to catch up on
+func streamSnapshot(c *gin.Context, topic string) []sse.NotificationEvent {
+	cl, _ := c.Get("cshauth")
+	claims := cl.(csh_auth.CSHClaims)
+
+	poll, err := database.GetPoll(c, topic)
+	if err != nil {
+		return nil
+	}
+	if poll.Hidden && !poll.CanManage(claims.UserInfo.Username, claims.UserInfo.Groups) {
+		return nil
+	}
+
+	event, err := resultsEvent(c, poll)
+	if err != nil {
+		return nil
+	}
+	return []sse.NotificationEvent{event}
+}
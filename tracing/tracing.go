@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName is what the app's spans are reported as coming from
+const ServiceName = "vote"
+
+// Tracer starts the spans the app makes itself. Until Setup is called, they
+// go nowhere
+var Tracer = otel.Tracer("github.com/computersciencehouse/vote")
+
+// Setup sends spans to an exporter: none, stdout for trying things out
+// locally, or otlp for a collector at endpoint. Without an endpoint, OTLP
+// uses the standard OTEL_EXPORTER_OTLP_* environment variables. The returned
+// function sends any spans left and stops exporting
+func Setup(ctx context.Context, exporter, endpoint string) (func(context.Context) error, error) {
+	// Trace context is propagated even without an exporter, so that this
+	// instance doesn't break traces passing through it
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		var err error
+		if spanExporter, err = stdouttrace.New(); err != nil {
+			return nil, err
+		}
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if endpoint != "" {
+			collector, err := url.Parse(endpoint)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, otlptracehttp.WithEndpoint(collector.Host))
+			if collector.Path != "" && collector.Path != "/" {
+				opts = append(opts, otlptracehttp.WithURLPath(collector.Path))
+			}
+			if collector.Scheme == "http" {
+				opts = append(opts, otlptracehttp.WithInsecure())
+			}
+		}
+		var err error
+		if spanExporter, err = otlptracehttp.New(ctx, opts...); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// RecordError marks a span as failed, if err isn't nil
+func RecordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}